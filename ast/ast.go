@@ -13,7 +13,22 @@ type Expression interface {
 }
 
 type Program struct {
-	Statements []Statement
+	Span
+	Statements []Statement `json:"statements"`
+}
+
+// Position is a position in the source of a program.
+type Position struct {
+	Offset int `json:"offset"` // Byte offset, starting at 0
+	Line   int `json:"line"`   // Line number, starting at 1
+	Column int `json:"column"` // Column number, starting at 1
+}
+
+// Span is the part of the source that a node was parsed from. Nodes
+// that were not parsed from source have an empty span.
+type Span struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
 }
 
 func (p *Program) ToString() string {
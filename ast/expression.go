@@ -2,10 +2,13 @@ package ast
 
 import (
 	"fmt"
+	"unicode"
+	"unicode/utf8"
 )
 
 type IdentifierExpression struct {
-	Literal string
+	Span
+	Literal string `json:"literal"`
 }
 
 func (e *IdentifierExpression) ToString() string {
@@ -13,7 +16,8 @@ func (e *IdentifierExpression) ToString() string {
 }
 
 type IntegerExpression struct {
-	Literal string
+	Span
+	Literal string `json:"literal"`
 }
 
 func (e *IntegerExpression) ToString() string {
@@ -21,9 +25,10 @@ func (e *IntegerExpression) ToString() string {
 }
 
 type InfixExpression struct {
-	Left     Expression
-	Operator string
-	Right    Expression
+	Span
+	Left     Expression `json:"left"`
+	Operator string     `json:"operator"`
+	Right    Expression `json:"right"`
 }
 
 func (e *InfixExpression) ToString() string {
@@ -43,8 +48,29 @@ func (e *InfixExpression) ToString() string {
 	)
 }
 
+type PrefixExpression struct {
+	Span
+	Operator string     `json:"operator"`
+	Right    Expression `json:"right"`
+}
+
+func (e *PrefixExpression) ToString() string {
+	var right string
+	if e.Right != nil {
+		right = e.Right.ToString()
+	}
+
+	// Keep word operators apart from their operand
+	format := "(%s%s)"
+	if r, _ := utf8.DecodeLastRuneInString(e.Operator); unicode.IsLetter(r) {
+		format = "(%s %s)"
+	}
+	return fmt.Sprintf(format, e.Operator, right)
+}
+
 type ClosedExpression struct {
-	Expression Expression
+	Span
+	Expression Expression `json:"expression"`
 }
 
 func (e *ClosedExpression) ToString() string {
@@ -52,8 +78,9 @@ func (e *ClosedExpression) ToString() string {
 }
 
 type FunctionExpression struct {
-	Parameters []string
-	Body       Statement
+	Span
+	Parameters []string  `json:"parameters"`
+	Body       Statement `json:"body"`
 }
 
 func (e *FunctionExpression) ToString() string {
@@ -68,8 +95,9 @@ func (e *FunctionExpression) ToString() string {
 }
 
 type CallExpression struct {
-	Identifier string
-	Arguments  []Expression
+	Span
+	Identifier string       `json:"identifier"`
+	Arguments  []Expression `json:"arguments"`
 }
 
 func (e *CallExpression) ToString() string {
@@ -0,0 +1,307 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Nodes are encoded as JSON objects with their fields and span, and
+// a "type" field with the name of their type, so that statements and
+// expressions can be decoded into the right type again:
+//
+//	{"type": "IdentifierExpression", "start": {...}, "end": {...}, "literal": "x"}
+//
+// Any node can be decoded with DecodeNode, or with json.Unmarshal into
+// a node of the type it was encoded from.
+
+// jsonNode holds the fields of any type of node, while decoding.
+type jsonNode struct {
+	Type string `json:"type"`
+	Span
+
+	Literal    string            `json:"literal"`
+	Operator   string            `json:"operator"`
+	Identifier string            `json:"identifier"`
+	Source     string            `json:"source"`
+	Parameters []string          `json:"parameters"`
+	Expression json.RawMessage   `json:"expression"`
+	Left       json.RawMessage   `json:"left"`
+	Right      json.RawMessage   `json:"right"`
+	Body       json.RawMessage   `json:"body"`
+	Arguments  []json.RawMessage `json:"arguments"`
+	Statements []json.RawMessage `json:"statements"`
+}
+
+// marshalNode encodes node, which must be of a type without a
+// MarshalJSON method, with a type tag in front of its fields.
+func marshalNode(kind string, node interface{}) ([]byte, error) {
+	fields, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	data := []byte(`{"type":` + fmt.Sprintf("%q", kind))
+	if len(fields) > 2 {
+		data = append(data, ',')
+	}
+	return append(data, fields[1:]...), nil
+}
+
+// DecodeNode decodes a node, as encoded by json.Marshal, into a value
+// of the type it was encoded from.
+func DecodeNode(data []byte) (Node, error) {
+	var n jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	if n.Type == "" {
+		return nil, nil
+	}
+
+	// Nodes within the node are decoded first
+	var fields [4]Node
+	for i, raw := range []json.RawMessage{n.Expression, n.Left, n.Right, n.Body} {
+		if len(raw) == 0 {
+			continue
+		}
+		field, err := DecodeNode(raw)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = field
+	}
+	expr, left, right, body := fields[0], fields[1], fields[2], fields[3]
+
+	var args []Expression
+	var stmts []Statement
+	for _, raw := range n.Arguments {
+		arg, err := DecodeNode(raw)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	for _, raw := range n.Statements {
+		stmt, err := DecodeNode(raw)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+
+	switch n.Type {
+	case "Program":
+		if stmts == nil {
+			stmts = []Statement{}
+		}
+		return &Program{Span: n.Span, Statements: stmts}, nil
+	case "DeclarationStatement":
+		return &DeclarationStatement{Span: n.Span, Identifier: n.Identifier, Expression: expr}, nil
+	case "ReturnStatement":
+		return &ReturnStatement{Span: n.Span, Expression: expr}, nil
+	case "ExpressionStatement":
+		return &ExpressionStatement{Span: n.Span, Expression: expr}, nil
+	case "BlockStatement":
+		return &BlockStatement{Span: n.Span, Statements: stmts}, nil
+	case "BadStatement":
+		return &BadStatement{Span: n.Span, Source: n.Source}, nil
+	case "IdentifierExpression":
+		return &IdentifierExpression{Span: n.Span, Literal: n.Literal}, nil
+	case "IntegerExpression":
+		return &IntegerExpression{Span: n.Span, Literal: n.Literal}, nil
+	case "InfixExpression":
+		return &InfixExpression{Span: n.Span, Left: left, Operator: n.Operator, Right: right}, nil
+	case "PrefixExpression":
+		return &PrefixExpression{Span: n.Span, Operator: n.Operator, Right: right}, nil
+	case "ClosedExpression":
+		return &ClosedExpression{Span: n.Span, Expression: expr}, nil
+	case "FunctionExpression":
+		return &FunctionExpression{Span: n.Span, Parameters: n.Parameters, Body: body}, nil
+	case "CallExpression":
+		return &CallExpression{Span: n.Span, Identifier: n.Identifier, Arguments: args}, nil
+	}
+	return nil, fmt.Errorf("ast: unknown node type %q", n.Type)
+}
+
+// decodeAs decodes a node that must be of type T.
+func decodeAs[T Node](data []byte) (T, error) {
+	node, err := DecodeNode(data)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	typed, ok := node.(T)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("ast: expected %T, got %T", zero, node)
+	}
+	return typed, nil
+}
+
+func (p *Program) MarshalJSON() ([]byte, error) {
+	type program Program
+	return marshalNode("Program", (*program)(p))
+}
+
+func (p *Program) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*Program](data)
+	if err == nil {
+		*p = *node
+	}
+	return err
+}
+
+func (ds *DeclarationStatement) MarshalJSON() ([]byte, error) {
+	type declarationStatement DeclarationStatement
+	return marshalNode("DeclarationStatement", (*declarationStatement)(ds))
+}
+
+func (ds *DeclarationStatement) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*DeclarationStatement](data)
+	if err == nil {
+		*ds = *node
+	}
+	return err
+}
+
+func (rs *ReturnStatement) MarshalJSON() ([]byte, error) {
+	type returnStatement ReturnStatement
+	return marshalNode("ReturnStatement", (*returnStatement)(rs))
+}
+
+func (rs *ReturnStatement) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*ReturnStatement](data)
+	if err == nil {
+		*rs = *node
+	}
+	return err
+}
+
+func (es *ExpressionStatement) MarshalJSON() ([]byte, error) {
+	type expressionStatement ExpressionStatement
+	return marshalNode("ExpressionStatement", (*expressionStatement)(es))
+}
+
+func (es *ExpressionStatement) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*ExpressionStatement](data)
+	if err == nil {
+		*es = *node
+	}
+	return err
+}
+
+func (s *BlockStatement) MarshalJSON() ([]byte, error) {
+	type blockStatement BlockStatement
+	return marshalNode("BlockStatement", (*blockStatement)(s))
+}
+
+func (s *BlockStatement) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*BlockStatement](data)
+	if err == nil {
+		*s = *node
+	}
+	return err
+}
+
+func (s *BadStatement) MarshalJSON() ([]byte, error) {
+	type badStatement BadStatement
+	return marshalNode("BadStatement", (*badStatement)(s))
+}
+
+func (s *BadStatement) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*BadStatement](data)
+	if err == nil {
+		*s = *node
+	}
+	return err
+}
+
+func (e *IdentifierExpression) MarshalJSON() ([]byte, error) {
+	type identifierExpression IdentifierExpression
+	return marshalNode("IdentifierExpression", (*identifierExpression)(e))
+}
+
+func (e *IdentifierExpression) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*IdentifierExpression](data)
+	if err == nil {
+		*e = *node
+	}
+	return err
+}
+
+func (e *IntegerExpression) MarshalJSON() ([]byte, error) {
+	type integerExpression IntegerExpression
+	return marshalNode("IntegerExpression", (*integerExpression)(e))
+}
+
+func (e *IntegerExpression) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*IntegerExpression](data)
+	if err == nil {
+		*e = *node
+	}
+	return err
+}
+
+func (e *InfixExpression) MarshalJSON() ([]byte, error) {
+	type infixExpression InfixExpression
+	return marshalNode("InfixExpression", (*infixExpression)(e))
+}
+
+func (e *InfixExpression) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*InfixExpression](data)
+	if err == nil {
+		*e = *node
+	}
+	return err
+}
+
+func (e *PrefixExpression) MarshalJSON() ([]byte, error) {
+	type prefixExpression PrefixExpression
+	return marshalNode("PrefixExpression", (*prefixExpression)(e))
+}
+
+func (e *PrefixExpression) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*PrefixExpression](data)
+	if err == nil {
+		*e = *node
+	}
+	return err
+}
+
+func (e *ClosedExpression) MarshalJSON() ([]byte, error) {
+	type closedExpression ClosedExpression
+	return marshalNode("ClosedExpression", (*closedExpression)(e))
+}
+
+func (e *ClosedExpression) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*ClosedExpression](data)
+	if err == nil {
+		*e = *node
+	}
+	return err
+}
+
+func (e *FunctionExpression) MarshalJSON() ([]byte, error) {
+	type functionExpression FunctionExpression
+	return marshalNode("FunctionExpression", (*functionExpression)(e))
+}
+
+func (e *FunctionExpression) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*FunctionExpression](data)
+	if err == nil {
+		*e = *node
+	}
+	return err
+}
+
+func (e *CallExpression) MarshalJSON() ([]byte, error) {
+	type callExpression CallExpression
+	return marshalNode("CallExpression", (*callExpression)(e))
+}
+
+func (e *CallExpression) UnmarshalJSON(data []byte) error {
+	node, err := decodeAs[*CallExpression](data)
+	if err == nil {
+		*e = *node
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package ast
+
+// Moved returns where this position is after the source before it was
+// edited, such that the position from is now at to. The source between
+// from and this position must be unchanged, so columns only move on
+// the line of from.
+func (p Position) Moved(from Position, to Position) Position {
+	if p.Line == from.Line {
+		p.Column += to.Column - from.Column
+	}
+	p.Offset += to.Offset - from.Offset
+	p.Line += to.Line - from.Line
+	return p
+}
+
+// Moved returns this span with both of its ends moved, see
+// Position.Moved.
+func (s Span) Moved(from Position, to Position) Span {
+	return Span{Start: s.Start.Moved(from, to), End: s.End.Moved(from, to)}
+}
+
+// Moved returns a copy of node, with its span and the spans of all
+// nodes within it moved as by Position.Moved, so that it may be reused
+// after the source before it was edited.
+func Moved(node Node, from Position, to Position) Node {
+	switch n := node.(type) {
+	case *Program:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		moved.Statements = movedStatements(n.Statements, from, to)
+		return &moved
+	case *DeclarationStatement:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		moved.Expression = Moved(n.Expression, from, to)
+		return &moved
+	case *ReturnStatement:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		moved.Expression = Moved(n.Expression, from, to)
+		return &moved
+	case *ExpressionStatement:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		moved.Expression = Moved(n.Expression, from, to)
+		return &moved
+	case *BlockStatement:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		moved.Statements = movedStatements(n.Statements, from, to)
+		return &moved
+	case *BadStatement:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		return &moved
+	case *IdentifierExpression:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		return &moved
+	case *IntegerExpression:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		return &moved
+	case *InfixExpression:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		moved.Left = Moved(n.Left, from, to)
+		moved.Right = Moved(n.Right, from, to)
+		return &moved
+	case *PrefixExpression:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		moved.Right = Moved(n.Right, from, to)
+		return &moved
+	case *ClosedExpression:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		moved.Expression = Moved(n.Expression, from, to)
+		return &moved
+	case *FunctionExpression:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		moved.Body = Moved(n.Body, from, to)
+		return &moved
+	case *CallExpression:
+		moved := *n
+		moved.Span = n.Span.Moved(from, to)
+		if n.Arguments != nil {
+			moved.Arguments = make([]Expression, len(n.Arguments))
+			for i, arg := range n.Arguments {
+				moved.Arguments[i] = Moved(arg, from, to)
+			}
+		}
+		return &moved
+	}
+	return node
+}
+
+func movedStatements(stmts []Statement, from Position, to Position) []Statement {
+	if stmts == nil {
+		return nil
+	}
+	moved := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		moved[i] = Moved(stmt, from, to)
+	}
+	return moved
+}
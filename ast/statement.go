@@ -5,8 +5,9 @@ import (
 )
 
 type DeclarationStatement struct {
-	Identifier string
-	Expression Expression
+	Span
+	Identifier string     `json:"identifier"`
+	Expression Expression `json:"expression"`
 }
 
 func (ds *DeclarationStatement) ToString() string {
@@ -18,7 +19,8 @@ func (ds *DeclarationStatement) ToString() string {
 }
 
 type ReturnStatement struct {
-	Expression Expression
+	Span
+	Expression Expression `json:"expression"`
 }
 
 func (rs *ReturnStatement) ToString() string {
@@ -29,7 +31,8 @@ func (rs *ReturnStatement) ToString() string {
 }
 
 type ExpressionStatement struct {
-	Expression Expression
+	Span
+	Expression Expression `json:"expression"`
 }
 
 func (es *ExpressionStatement) ToString() string {
@@ -40,7 +43,8 @@ func (es *ExpressionStatement) ToString() string {
 }
 
 type BlockStatement struct {
-	Statements []Statement
+	Span
+	Statements []Statement `json:"statements"`
 }
 
 func (s *BlockStatement) ToString() string {
@@ -50,3 +54,17 @@ func (s *BlockStatement) ToString() string {
 	}
 	return str + "}"
 }
+
+// BadStatement is a placeholder for source text that could
+// not be parsed as a statement.
+type BadStatement struct {
+	Span
+	Source string `json:"source"`
+}
+
+func (s *BadStatement) ToString() string {
+	return fmt.Sprintf(
+		"<bad statement: %s>\n",
+		s.Source,
+	)
+}
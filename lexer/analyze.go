@@ -0,0 +1,314 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProblemKind is the kind of mistake in a grammar found by Analyze.
+type ProblemKind int
+
+const (
+	// A Repeat or Interlace that can go on without consuming input,
+	// and so never stops before reaching its maximum, if it has one.
+	NullableRepeat ProblemKind = iota
+	// An alternative of an Or that is never tried, or never gets to
+	// match, as an alternative before it always matches first.
+	UnreachableAlternative
+	// A Future whose pointer was never set to a lexer.
+	UndefinedFuture
+	// A rule that refers to itself before consuming any input. This
+	// is supported, but slower.
+	LeftRecursion
+)
+
+func (k ProblemKind) String() string {
+	return [...]string{
+		"nullable repeat",
+		"unreachable alternative",
+		"undefined future",
+		"left recursion",
+	}[k]
+}
+
+// Problem is a mistake in a grammar found by Analyze. Path holds the
+// names of the rules that lead from the root of the grammar to the
+// rule the problem is in, which comes last.
+type Problem struct {
+	Kind    ProblemKind
+	Path    []string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", strings.Join(p.Path, " > "), p.Kind, p.Message)
+}
+
+// Analyze looks for mistakes in the grammar rooted at root, which is
+// split into rules the same way as by EBNF. Each mistake is reported
+// once, in the first rule it is found in.
+func Analyze(root Lexer) []Problem {
+	a := &analyzer{
+		rules:    grammarRules(root),
+		nullable: make(map[*FutureLexer]bool),
+	}
+	a.findNullable()
+
+	var problems []Problem
+	seen := make(map[Lexer]bool)
+	for i, rule := range a.rules {
+		report := func(kind ProblemKind, format string, args ...interface{}) {
+			problems = append(problems, Problem{
+				Kind:    kind,
+				Path:    a.path(i),
+				Message: fmt.Sprintf(format, args...),
+			})
+		}
+
+		if unwrap(rule.body) == nil {
+			report(UndefinedFuture, "%s is used, but never defined", rule.name)
+			continue
+		}
+		for _, l := range a.leftmost(rule.body) {
+			if l == Lexer(rule.future) {
+				report(LeftRecursion, "%s may start with itself", rule.name)
+				break
+			}
+		}
+
+		var walk func(l Lexer)
+		walk = func(l Lexer) {
+			if seen[l] {
+				return
+			}
+			seen[l] = true
+
+			switch p := l.(type) {
+			case *FutureLexer:
+				return
+			case *RepeatLexer:
+				if p.max != 1 && a.isNullable(p.child) {
+					report(NullableRepeat, "%s repeats something that may match nothing",
+						ebnfAt(p, ebnfChoice))
+				}
+			case *InterlaceLexer:
+				if a.isNullable(p.outer) && a.isNullable(p.inner) {
+					report(NullableRepeat, "%s repeats something that may match nothing",
+						ebnfAt(p, ebnfChoice))
+				}
+			case *OrLexer:
+				for j, child := range p.children {
+					for _, earlier := range p.children[:j] {
+						if a.shadows(earlier, child) {
+							report(UnreachableAlternative, "%s is never matched, as %s matches first",
+								ebnfAt(child, ebnfSequence), ebnfAt(earlier, ebnfSequence))
+							break
+						}
+					}
+				}
+			}
+			for _, child := range children(l) {
+				walk(child)
+			}
+		}
+		walk(rule.body)
+	}
+	return problems
+}
+
+type analyzer struct {
+	rules    []grammarRule
+	nullable map[*FutureLexer]bool
+}
+
+// path returns the names of the rules leading up to rule i.
+func (a *analyzer) path(i int) []string {
+	var path []string
+	for ; i >= 0; i = a.rules[i].parent {
+		path = append([]string{a.rules[i].name}, path...)
+	}
+	return path
+}
+
+// findNullable finds the rules that may match without consuming any
+// input. A rule is assumed not to until shown otherwise, which is
+// repeated for as long as more of them turn out to be.
+func (a *analyzer) findNullable() {
+	for changed := true; changed; {
+		changed = false
+		for _, rule := range a.rules {
+			if rule.future != nil && rule.body != nil &&
+				!a.nullable[rule.future] && a.isNullable(rule.body) {
+				a.nullable[rule.future] = true
+				changed = true
+			}
+		}
+	}
+}
+
+// isNullable returns whether l may match without consuming input.
+func (a *analyzer) isNullable(l Lexer) bool {
+	switch p := l.(type) {
+	case *FutureLexer:
+		return a.nullable[p]
+	case *AtomLexer:
+		return p.atom == ""
+	case *RegexLexer:
+		return p.allowEmpty && p.regexp.MatchString("")
+	case *RuneLexer, *RuneClassLexer:
+		return false
+	case *BlockCommentLexer:
+		return p.open == ""
+	case *LineCommentLexer:
+		return p.prefix == ""
+	case *CutLexer, *PeekLexer, *NotLexer, *EOFLexer,
+		*IndentLexer, *SameIndentLexer, *DedentLexer:
+		return true
+	case *TriviaLexer:
+		return p.allowEmpty
+	case *RepeatLexer:
+		return p.min <= 0 || a.isNullable(p.child)
+	case *AndLexer:
+		for _, child := range p.children {
+			if !a.isNullable(child) {
+				return false
+			}
+		}
+		return true
+	case *OrLexer:
+		for _, child := range p.children {
+			if a.isNullable(child) {
+				return true
+			}
+		}
+		return false
+	case *InterlaceLexer:
+		return a.isNullable(p.outer)
+	case *RecoverLexer:
+		return a.isNullable(p.child)
+	case *OperatorsLexer:
+		return a.isNullable(p.operand)
+	}
+
+	// Whatever is left matches its only child
+	if children := children(l); len(children) == 1 {
+		return a.isNullable(children[0])
+	}
+	return false
+}
+
+// leftmost returns the lexers that l may start with, applied at the
+// same position as l, without following Futures.
+func (a *analyzer) leftmost(l Lexer) []Lexer {
+	var lexers []Lexer
+	var walk func(l Lexer)
+	walk = func(l Lexer) {
+		lexers = append(lexers, l)
+		switch p := l.(type) {
+		case *FutureLexer:
+			return
+		case *AndLexer:
+			for _, child := range p.children {
+				walk(child)
+				if !a.isNullable(child) {
+					return
+				}
+			}
+			return
+		case *InterlaceLexer:
+			walk(p.outer)
+			return
+		case *RecoverLexer:
+			walk(p.child)
+			return
+		case *OperatorsLexer:
+			walk(p.operand)
+			for _, op := range p.prefix {
+				walk(op.Token)
+			}
+			return
+		}
+		for _, child := range children(l) {
+			walk(child)
+		}
+	}
+	walk(l)
+	return lexers
+}
+
+// shadows returns whether earlier, as an alternative before later,
+// keeps later from ever matching: either because earlier always
+// matches, or because it matches the start of everything later does.
+func (a *analyzer) shadows(earlier Lexer, later Lexer) bool {
+	if earlier == later || a.alwaysMatches(earlier) {
+		return true
+	}
+
+	prefix, _ := literalPrefix(later)
+	if prefix == "" {
+		return false
+	}
+	switch p := unwrap(earlier).(type) {
+	case *RegexLexer:
+		match := p.regexp.FindStringIndex(prefix)
+		return match != nil && (match[1] > 0 || p.allowEmpty)
+	}
+	literal, exact := literalPrefix(earlier)
+	return exact && strings.HasPrefix(prefix, literal)
+}
+
+// alwaysMatches returns whether l matches wherever it is applied.
+func (a *analyzer) alwaysMatches(l Lexer) bool {
+	switch p := unwrap(l).(type) {
+	case *RegexLexer:
+		return p.allowEmpty && p.regexp.MatchString("")
+	case *CutLexer:
+		return true
+	case *TriviaLexer:
+		return p.allowEmpty
+	case *RepeatLexer:
+		return p.min <= 0
+	case *PeekLexer:
+		return a.alwaysMatches(p.child)
+	case *AndLexer:
+		for _, child := range p.children {
+			if !a.alwaysMatches(child) {
+				return false
+			}
+		}
+		return true
+	case *OrLexer:
+		for _, child := range p.children {
+			if a.alwaysMatches(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// literalPrefix returns the text that everything l matches starts
+// with, and whether that's all l ever matches.
+func literalPrefix(l Lexer) (string, bool) {
+	switch p := unwrap(l).(type) {
+	case *AtomLexer:
+		return p.atom, true
+	case *RuneLexer:
+		return string(p.r), true
+	case *KeywordLexer:
+		return p.word, false
+	case *AndLexer:
+		var prefix string
+		for _, child := range p.children {
+			literal, exact := literalPrefix(child)
+			prefix += literal
+			if !exact {
+				return prefix, false
+			}
+		}
+		return prefix, true
+	case *CutLexer:
+		return "", true
+	}
+	return "", false
+}
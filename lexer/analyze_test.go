@@ -0,0 +1,70 @@
+package lexer
+
+import (
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+
+	var expr, sum, term Lexer
+	lExpr := Future(&expr, "expr")
+	lSum := Future(&sum, "sum")
+	lTerm := Future(&term, "term")
+	lSpace := Future(new(Lexer), "space")
+	*lSpace.pointer = Regex("[ ]*", true)
+	expr = Or(lSum, Atom("x"))
+	sum = And(lSum, Atom("+"), lTerm)
+	term = Or(Atom(">"), Atom(">="))
+
+	word := Regex("[a-z]+", false)
+
+	tests := []struct {
+		root     Lexer
+		problems []string
+	}{
+		{And(Atom("a"), Repeat(Atom("b"), 0, -1)), nil},
+		{Or(Atom(">="), Atom(">"), Keyword("let", word), word), nil},
+
+		// Nullable repeats, including through rules
+		{Repeat(Regex("[a-z]*", true), 0, -1),
+			[]string{"grammar: nullable repeat: /[a-z]*/* repeats something that may match nothing"}},
+		{Interlace(Optional(Atom("a")), Optional(Atom(","))),
+			[]string{`grammar: nullable repeat: "a"? (","? "a"?)* repeats something that may match nothing`}},
+		{And(Atom("("), Repeat(And(lSpace, Peek(Atom("x"))), 1, -1)),
+			[]string{`grammar: nullable repeat: (space &"x")+ repeats something that may match nothing`}},
+
+		// Alternatives that never match
+		{Or(word, Keyword("let", word), Atom("x")),
+			[]string{
+				`grammar: unreachable alternative: "let" !/[a-z]+/ is never matched, as /[a-z]+/ matches first`,
+				`grammar: unreachable alternative: "x" is never matched, as /[a-z]+/ matches first`,
+			}},
+		{Or(Optional(Atom("a")), Atom("b")),
+			[]string{`grammar: unreachable alternative: "b" is never matched, as "a"? matches first`}},
+		{Or(Group("then", Atom("then")), And(Atom("then"), Atom("!"))),
+			[]string{`grammar: unreachable alternative: "then" "!" is never matched, as "then" matches first`}},
+
+		// Undefined rules and left recursion, with the path to them
+		{And(lExpr, Future(new(Lexer), "missing")),
+			[]string{
+				"grammar > missing: undefined future: missing is used, but never defined",
+				"grammar > expr > sum: left recursion: sum may start with itself",
+				"grammar > expr > sum > term: unreachable alternative: \">=\" is never matched, as \">\" matches first",
+			}},
+	}
+
+	for i, tt := range tests {
+		var problems []string
+		for _, problem := range Analyze(tt.root) {
+			problems = append(problems, problem.String())
+		}
+		if len(problems) != len(tt.problems) {
+			t.Fatalf("TestAnalyze[%d]: expected problems=%q got=%q", i, tt.problems, problems)
+		}
+		for j := range problems {
+			if problems[j] != tt.problems[j] {
+				t.Fatalf("TestAnalyze[%d]: expected problems=%q got=%q", i, tt.problems, problems)
+			}
+		}
+	}
+}
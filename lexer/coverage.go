@@ -0,0 +1,260 @@
+package lexer
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+)
+
+// Coverage counts how often the Groups and Futures of a grammar
+// matched and failed, and which alternatives of each Or were taken,
+// over any number of scanners, see Scanner.EnableCoverage. It may
+// be shared by scanners in several goroutines.
+type Coverage struct {
+	mu           sync.Mutex
+	matches      map[Lexer]*[2]int // matched and failed, by Group or Future
+	alternatives map[*OrLexer][]int
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{
+		matches:      make(map[Lexer]*[2]int),
+		alternatives: make(map[*OrLexer][]int),
+	}
+}
+
+// EnableCoverage makes this scanner count what is lexed in c. With
+// memoization, results that are replayed are counted again for the
+// Group or Future they are stored for, but not for the lexers within.
+func (s *Scanner) EnableCoverage(c *Coverage) {
+	s.coverage = c
+}
+
+// cover counts a match or failure of the Group or Future lex.
+func (s *Scanner) cover(lex Lexer, matched bool) {
+	c := s.coverage
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts, ok := c.matches[lex]
+	if !ok {
+		counts = new([2]int)
+		c.matches[lex] = counts
+	}
+	if matched {
+		counts[0]++
+	} else {
+		counts[1]++
+	}
+}
+
+// coverAlternative counts that alternative i of or was taken.
+func (s *Scanner) coverAlternative(or *OrLexer, i int) {
+	c := s.coverage
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	taken, ok := c.alternatives[or]
+	if !ok {
+		taken = make([]int, len(or.children))
+		c.alternatives[or] = taken
+	}
+	taken[i]++
+}
+
+// CoverageReport is the coverage of a grammar, rule by rule.
+type CoverageReport struct {
+	Rules []RuleCoverage
+}
+
+// RuleCoverage is the coverage of a rule, see EBNF, and of the Groups
+// and Ors within it, in the order they appear in the rule. Lexers that
+// appear in more than one rule are only reported for the first. Only
+// rules that are a Future or Group are counted, so a root that is
+// neither never matches.
+type RuleCoverage struct {
+	Name    string
+	Matched int
+	Failed  int
+	Groups  []GroupCoverage
+	Choices []ChoiceCoverage
+}
+
+type GroupCoverage struct {
+	Name    string
+	Matched int
+	Failed  int
+}
+
+// ChoiceCoverage is the coverage of an Or, with the EBNF of each of
+// its alternatives and how often it was taken.
+type ChoiceCoverage struct {
+	Alternatives []string
+	Taken        []int
+}
+
+// Report returns the coverage of the grammar rooted at root.
+func (c *Coverage) Report(root Lexer) *CoverageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := func(l Lexer) (int, int) {
+		if counts, ok := c.matches[l]; ok {
+			return counts[0], counts[1]
+		}
+		return 0, 0
+	}
+
+	report := &CoverageReport{}
+	seen := make(map[Lexer]bool)
+	for _, rule := range grammarRules(root) {
+		ruleCoverage := RuleCoverage{Name: rule.name}
+		if rule.future != nil {
+			ruleCoverage.Matched, ruleCoverage.Failed = counts(rule.future)
+		} else {
+			ruleCoverage.Matched, ruleCoverage.Failed = counts(rule.body)
+		}
+
+		// Walk the body of the rule, up to the rules within it
+		var walk func(l Lexer)
+		walk = func(l Lexer) {
+			if _, ok := l.(*FutureLexer); ok || l == nil || seen[l] {
+				return
+			}
+			seen[l] = true
+
+			switch l := l.(type) {
+			case *GroupLexer:
+				group := GroupCoverage{Name: l.groupName}
+				group.Matched, group.Failed = counts(l)
+				ruleCoverage.Groups = append(ruleCoverage.Groups, group)
+			case *OrLexer:
+				choice := ChoiceCoverage{Taken: make([]int, len(l.children))}
+				for _, child := range l.children {
+					choice.Alternatives = append(choice.Alternatives, ebnfAt(child, ebnfSequence))
+				}
+				copy(choice.Taken, c.alternatives[l])
+				ruleCoverage.Choices = append(ruleCoverage.Choices, choice)
+			}
+			for _, child := range children(l) {
+				walk(child)
+			}
+		}
+		walk(rule.body)
+		report.Rules = append(report.Rules, ruleCoverage)
+	}
+	return report
+}
+
+// Summary returns how many rules matched at least once, and how many
+// alternatives were taken at least once, out of how many there are.
+func (r *CoverageReport) Summary() (rules int, totalRules int, taken int, totalTaken int) {
+	for _, rule := range r.Rules {
+		totalRules++
+		if rule.Matched > 0 {
+			rules++
+		}
+		for _, choice := range rule.Choices {
+			for _, n := range choice.Taken {
+				totalTaken++
+				if n > 0 {
+					taken++
+				}
+			}
+		}
+	}
+	return rules, totalRules, taken, totalTaken
+}
+
+// Text returns the report as plain text, marking the rules that never
+// matched and the alternatives that were never taken.
+func (r *CoverageReport) Text() string {
+	var b strings.Builder
+	rules, totalRules, taken, totalTaken := r.Summary()
+	fmt.Fprintf(&b, "%d of %d rules matched, %d of %d alternatives taken\n",
+		rules, totalRules, taken, totalTaken)
+
+	for _, rule := range r.Rules {
+		fmt.Fprintf(&b, "\nrule %s: %d matched, %d failed", rule.Name, rule.Matched, rule.Failed)
+		if rule.Matched == 0 {
+			b.WriteString("  <- never matched")
+		}
+		b.WriteString("\n")
+		for _, group := range rule.Groups {
+			fmt.Fprintf(&b, "  group %s: %d matched, %d failed\n", group.Name, group.Matched, group.Failed)
+		}
+		for _, choice := range rule.Choices {
+			b.WriteString("  choice:\n")
+			for i, alternative := range choice.Alternatives {
+				fmt.Fprintf(&b, "    %6d  %s", choice.Taken[i], alternative)
+				if choice.Taken[i] == 0 {
+					b.WriteString("  <- never taken")
+				}
+				b.WriteString("\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// HTML returns the report as a standalone HTML page, with the rules
+// that never matched and the alternatives that were never taken
+// highlighted.
+func (r *CoverageReport) HTML() string {
+	var b strings.Builder
+	rules, totalRules, taken, totalTaken := r.Summary()
+	b.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Grammar coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin: 0.5em 0 1.5em 1em; }
+td { padding: 0.1em 0.8em; vertical-align: top; }
+td.count { text-align: right; }
+code { font-family: monospace; white-space: pre-wrap; }
+.missed { background: #fdd; }
+.covered { background: #dfd; }
+</style>
+</head>
+<body>
+<h1>Grammar coverage</h1>
+`)
+	fmt.Fprintf(&b, "<p>%d of %d rules matched, %d of %d alternatives taken</p>\n",
+		rules, totalRules, taken, totalTaken)
+
+	class := func(n int) string {
+		if n == 0 {
+			return "missed"
+		}
+		return "covered"
+	}
+	for _, rule := range r.Rules {
+		fmt.Fprintf(&b, "<h2 class=\"%s\">%s</h2>\n<p>%d matched, %d failed</p>\n",
+			class(rule.Matched), html.EscapeString(rule.Name), rule.Matched, rule.Failed)
+		if len(rule.Groups) > 0 {
+			b.WriteString("<table>\n<tr><th>group</th><th>matched</th><th>failed</th></tr>\n")
+			for _, group := range rule.Groups {
+				fmt.Fprintf(&b, "<tr class=\"%s\"><td><code>%s</code></td><td class=\"count\">%d</td><td class=\"count\">%d</td></tr>\n",
+					class(group.Matched), html.EscapeString(group.Name), group.Matched, group.Failed)
+			}
+			b.WriteString("</table>\n")
+		}
+		for _, choice := range rule.Choices {
+			b.WriteString("<table>\n<tr><th>alternative</th><th>taken</th></tr>\n")
+			for i, alternative := range choice.Alternatives {
+				fmt.Fprintf(&b, "<tr class=\"%s\"><td><code>%s</code></td><td class=\"count\">%d</td></tr>\n",
+					class(choice.Taken[i]), html.EscapeString(alternative), choice.Taken[i])
+			}
+			b.WriteString("</table>\n")
+		}
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
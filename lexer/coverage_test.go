@@ -0,0 +1,66 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+
+	// value = number | "(" value ")" | "[" value "]"
+	var value Lexer
+	lValue := Future(&value, "value")
+	number := Group("number", Regex("[0-9]+", false))
+	value = Or(number, And(Atom("("), lValue, Atom(")")), And(Atom("["), lValue, Atom("]")))
+	var start Lexer
+	root := Future(&start, "start")
+	start = And(lValue, EOF())
+
+	tests := []struct {
+		inputs   []string
+		expected []string // lines the text report must contain
+	}{
+		{[]string{}, []string{
+			"0 of 2 rules matched, 0 of 3 alternatives taken",
+			"rule value: 0 matched, 0 failed  <- never matched",
+			"      0  /[0-9]+/  <- never taken",
+		}},
+		{[]string{"1", "(2)", "((3))"}, []string{
+			"2 of 2 rules matched, 2 of 3 alternatives taken",
+			"rule start: 3 matched, 0 failed",
+			"rule value: 6 matched, 0 failed",
+			"  group number: 3 matched, 3 failed",
+			"      3  /[0-9]+/\n",
+			"      3  \"(\" value \")\"\n",
+			"      0  \"[\" value \"]\"  <- never taken",
+		}},
+		{[]string{"[x]", "[1]"}, []string{
+			"2 of 2 rules matched, 2 of 3 alternatives taken",
+			"rule start: 1 matched, 1 failed",
+			"rule value: 2 matched, 2 failed",
+			"      0  \"(\" value \")\"  <- never taken",
+		}},
+	}
+
+	for i, tt := range tests {
+		c := NewCoverage()
+		for _, input := range tt.inputs {
+			s := NewScanner(input)
+			s.EnableCoverage(c)
+			root.Lex(s)
+		}
+
+		report := c.Report(root)
+		text := report.Text()
+		for _, line := range tt.expected {
+			if !strings.Contains(text, line) {
+				t.Fatalf("TestCoverage[%d]: expected %q in report, got=\n%s", i, line, text)
+			}
+		}
+
+		page := report.HTML()
+		if !strings.Contains(page, "<code>&#34;[&#34; value &#34;]&#34;</code>") {
+			t.Fatalf("TestCoverage[%d]: expected escaped alternative in HTML, got=\n%s", i, page)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// How tightly the parts of an EBNF expression bind, to tell where
+// parentheses are needed.
+const (
+	ebnfChoice = iota
+	ebnfSequence
+	ebnfUnary
+	ebnfPrimary
+)
+
+// EBNF returns the grammar rooted at root in the EBNF notation of the
+// W3C XML specification, with a rule for every Future reachable from
+// root, see also Railroad. Regexes are written as /pattern/, and
+// whatever has no notation of its own, such as the end of input or
+// indentation, in angle brackets. Groups and cuts don't change what
+// is matched, so they are left out.
+func EBNF(root Lexer) string {
+	var b strings.Builder
+	for _, rule := range grammarRules(root) {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(rule.name + " ::= ")
+
+		// Put the alternatives of a rule on lines of their own
+		body := unwrap(rule.body)
+		if or, ok := body.(*OrLexer); ok && len(or.children) > 1 {
+			indent := "\n" + strings.Repeat(" ", len(rule.name)+3) + "| "
+			for i, child := range or.children {
+				if i > 0 {
+					b.WriteString(indent)
+				}
+				b.WriteString(ebnfAt(child, ebnfSequence))
+			}
+		} else {
+			b.WriteString(ebnfAt(body, ebnfChoice))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// unwrap skips the lexers that don't change what is matched.
+func unwrap(l Lexer) Lexer {
+	for {
+		switch p := l.(type) {
+		case *GroupLexer:
+			l = p.child
+		case *IgnoreLexer:
+			l = p.child
+		case *RecoverLexer:
+			l = p.child
+		case wrapper:
+			l = p.wrapped()
+		default:
+			return l
+		}
+	}
+}
+
+// ebnfAt returns l as an expression that binds at least as tightly
+// as the given level, putting it in parentheses if needed.
+func ebnfAt(l Lexer, level int) string {
+	str, actual := ebnf(l)
+	if actual < level {
+		return "(" + str + ")"
+	}
+	return str
+}
+
+// ebnf returns l as an expression, and how tightly it binds. Cuts
+// are returned as an empty string.
+func ebnf(l Lexer) (string, int) {
+	switch p := unwrap(l).(type) {
+
+	case nil:
+		return "<undefined>", ebnfPrimary
+	case *FutureLexer:
+		return p.name, ebnfPrimary
+	case *CutLexer:
+		return "", ebnfPrimary
+
+	// Terminals
+	case *AtomLexer:
+		return strconv.Quote(p.atom), ebnfPrimary
+	case *RuneLexer:
+		return strconv.Quote(string(p.r)), ebnfPrimary
+	case *RegexLexer:
+		return "/" + p.pattern + "/", ebnfPrimary
+	case *RuneClassLexer:
+		return "<class(" + strings.Join(p.names(), ", ") + ")>", ebnfPrimary
+	case *EOFLexer:
+		return "<end of input>", ebnfPrimary
+	case *IndentLexer:
+		return "<indent>", ebnfPrimary
+	case *SameIndentLexer:
+		return "<same indent>", ebnfPrimary
+	case *DedentLexer:
+		return "<dedent>", ebnfPrimary
+	case *BlockCommentLexer:
+		return strconv.Quote(p.open) + " <nested comment text> " + strconv.Quote(p.close), ebnfSequence
+	case *LineCommentLexer:
+		return strconv.Quote(p.prefix) + " <rest of line>", ebnfSequence
+
+	case *AndLexer:
+		var parts []string
+		var last Lexer
+		for _, child := range p.children {
+			if part := ebnfAt(child, ebnfSequence); part != "" {
+				parts = append(parts, part)
+				last = child
+			}
+		}
+		if len(parts) == 1 {
+			return ebnf(last)
+		}
+		return strings.Join(parts, " "), ebnfSequence
+
+	case *OrLexer:
+		alternatives := make([]string, len(p.children))
+		for i, child := range p.children {
+			alternatives[i] = ebnfAt(child, ebnfSequence)
+		}
+		return strings.Join(alternatives, " | "), ebnfChoice
+
+	case *RepeatLexer:
+		return ebnfAt(p.child, ebnfPrimary) + ebnfQuantifier(p.min, p.max), ebnfUnary
+
+	case *InterlaceLexer:
+		outer := ebnfAt(p.outer, ebnfSequence)
+		inner := ebnfAt(p.inner, ebnfSequence)
+		return fmt.Sprintf("%s (%s %s)*", outer, inner, outer), ebnfSequence
+
+	case *KeywordLexer:
+		return ebnf(p.lexer)
+	case *PeekLexer:
+		return "&" + ebnfAt(p.child, ebnfPrimary), ebnfUnary
+	case *NotLexer:
+		return "!" + ebnfAt(p.child, ebnfPrimary), ebnfUnary
+
+	case *ReservedLexer:
+		words := make([]string, len(p.list))
+		for i, word := range p.list {
+			words[i] = strconv.Quote(word)
+		}
+		excluded := strings.Join(words, " | ")
+		if len(words) > 1 {
+			excluded = "(" + excluded + ")"
+		}
+		return ebnfAt(p.child, ebnfUnary) + " - " + excluded, ebnfChoice
+
+	case *TriviaLexer:
+		alternatives := make([]string, len(p.alternatives))
+		for i, child := range p.alternatives {
+			alternatives[i] = ebnfAt(child, ebnfSequence)
+		}
+		quantifier := "*"
+		if !p.allowEmpty {
+			quantifier = "+"
+		}
+		if len(alternatives) == 1 {
+			return ebnfAt(p.space, ebnfPrimary) + quantifier, ebnfUnary
+		}
+		return "(" + strings.Join(alternatives, " | ") + ")" + quantifier, ebnfUnary
+
+	case *OperatorsLexer:
+		return ebnfOperators(p), ebnfSequence
+	}
+
+	return "<" + l.ToString() + ">", ebnfPrimary
+}
+
+func ebnfQuantifier(min int, max int) string {
+	switch {
+	case min == 0 && max == 1:
+		return "?"
+	case min == 0 && max < 0:
+		return "*"
+	case min == 1 && max < 0:
+		return "+"
+	case max < 0:
+		return fmt.Sprintf("{%d,}", min)
+	case min == max:
+		return fmt.Sprintf("{%d}", min)
+	}
+	return fmt.Sprintf("{%d,%d}", min, max)
+}
+
+// ebnfOperators writes out an operator table as a sequence of
+// operands and operators. The precedence of the operators can't be
+// told from that, but they are listed from loosest to tightest.
+func ebnfOperators(p *OperatorsLexer) string {
+	tokens := func(ops []Operator) string {
+		var alternatives []string
+		for _, op := range ops {
+			token := unwrap(op.Token)
+			if or, ok := token.(*OrLexer); ok {
+				for _, child := range or.children {
+					alternatives = append(alternatives, ebnfAt(child, ebnfSequence))
+				}
+				continue
+			}
+			alternatives = append(alternatives, ebnfAt(token, ebnfSequence))
+		}
+		if len(alternatives) == 1 {
+			return alternatives[0]
+		}
+		return "(" + strings.Join(alternatives, " | ") + ")"
+	}
+	space := ""
+	if p.space != nil {
+		space = ebnfAt(p.space, ebnfPrimary)
+	}
+	join := func(parts ...string) string {
+		var nonEmpty []string
+		for _, part := range parts {
+			if part != "" {
+				nonEmpty = append(nonEmpty, part)
+			}
+		}
+		return strings.Join(nonEmpty, " ")
+	}
+
+	operand := ebnfAt(p.operand, ebnfSequence)
+	if len(p.prefix) > 0 {
+		operand = "(" + join(tokens(p.prefix), space) + ")* " + operand
+	}
+	if len(p.postfix) > 0 {
+		operand += " (" + join(space, tokens(p.postfix)) + ")*"
+	}
+	if len(p.infix) == 0 {
+		return operand
+	}
+	return fmt.Sprintf("%s (%s %s)*", operand, join(space, tokens(p.infix), space), operand)
+}
@@ -0,0 +1,48 @@
+package lexer
+
+import (
+	"testing"
+)
+
+func TestEBNF(t *testing.T) {
+
+	var expr Lexer
+	lExpr := Future(&expr, "expr")
+	lNumber := Future(new(Lexer), "number")
+	*lNumber.pointer = Group("number", Regex("[0-9]+", false))
+	expr = Or(
+		And(Atom("("), Cut(), lExpr, Atom(")")),
+		Operators(lNumber, nil,
+			InfixOp(1, LeftAssoc, Or(Atom("+"), Atom("-"))),
+			PrefixOp(2, Atom("-")),
+		),
+	)
+	word := Regex("[a-z]", false)
+
+	tests := []struct {
+		root     Lexer
+		expected string
+	}{
+		{Atom("x"), "grammar ::= \"x\"\n"},
+		{And(Atom("a"), Or(Atom("b"), Atom("c")), Repeat(Atom("d"), 0, -1)),
+			"grammar ::= \"a\" (\"b\" | \"c\") \"d\"*\n"},
+		{Or(And(Atom("a"), Atom("b")), Repeat(Or(Atom("c"), Atom("d")), 2, 3)),
+			"grammar ::= \"a\" \"b\"\n          | (\"c\" | \"d\"){2,3}\n"},
+		{And(Keyword("if", word), Not(Atom("!")), Optional(Peek(EOF()))),
+			"grammar ::= \"if\" !/[a-z]/ !\"!\" (&<end of input>)?\n"},
+		{Interlace(Reserved(word, "x"), Trivia(Atom(" "), false, LineComment("#"))),
+			"grammar ::= (/[a-z]/ - \"x\") ((\" \" | \"#\" <rest of line>)+ (/[a-z]/ - \"x\"))*\n"},
+		{And(Atom(":"), Indent(), SameIndent(), Dedent(), Future(new(Lexer), "missing")),
+			"grammar ::= \":\" <indent> <same indent> <dedent> missing\n\nmissing ::= <undefined>\n"},
+		{lExpr, "expr ::= \"(\" expr \")\"\n" +
+			"       | (\"-\")* number ((\"+\" | \"-\") (\"-\")* number)*\n\n" +
+			"number ::= /[0-9]+/\n"},
+	}
+
+	for i, tt := range tests {
+		ebnf := EBNF(tt.root)
+		if ebnf != tt.expected {
+			t.Fatalf("TestEBNF[%d]: expected=%q got=%q", i, tt.expected, ebnf)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package lexer
+
+// Checkpoint is the state of a scanner in between two lexers, such as
+// two statements of a program. Lexing may be resumed from it on the
+// same input, or on an edited one, as long as the edit comes after
+// all input that was examined to get there, see Examined.
+type Checkpoint struct {
+	state     scannerState
+	lineStart bool
+
+	// The farthest failure so far, which later errors may report
+	failure  scannerState
+	expected []string
+
+	examined int
+}
+
+// EnableCheckpoints makes this scanner keep exact track of how far it
+// examined the input, see Checkpoint.Examined. Without it, matching a
+// regex counts as examining all input, and matching one with it is
+// slower.
+func (s *Scanner) EnableCheckpoints() {
+	s.checkpoints = true
+}
+
+// examine records that the input up to offset was examined.
+func (s *Scanner) examine(offset int) {
+	if offset > s.examined {
+		s.examined = offset
+	}
+}
+
+// Checkpoint returns the current state of the scanner. With
+// checkpoints enabled, memoized results are dropped, so that lexing
+// on from the checkpoint does not depend on what was lexed before it.
+func (s *Scanner) Checkpoint() Checkpoint {
+	if s.memo != nil && s.checkpoints {
+		s.memo = make(map[memoKey]*memoEntry)
+	}
+	return Checkpoint{
+		state:     s.state(),
+		lineStart: s.atLineStart(),
+		failure:   s.failure,
+		expected:  s.expected[:len(s.expected):len(s.expected)],
+		examined:  s.examined,
+	}
+}
+
+// Resume continues from the checkpoint c, which may have been taken
+// on another input that is the same as this one up to c.Examined.
+func (s *Scanner) Resume(c Checkpoint) {
+	s.restore(c.state)
+	s.indexLines(s.index)
+	s.failure = c.failure
+	s.expected = c.expected
+	s.examined = c.examined
+}
+
+// Position returns where the scanner was at the checkpoint.
+func (c Checkpoint) Position() Position {
+	return c.state.position()
+}
+
+// Examined returns the offset up to which the input was examined to
+// get to the checkpoint. Input at and after it may have been checked
+// to exist, so an edit at Examined - 1 may change what is lexed.
+func (c Checkpoint) Examined() int {
+	return c.examined
+}
+
+// Continues returns whether lexing from this checkpoint goes on as it
+// did from old, a checkpoint on the input before an edit, as long as
+// the input after both is the same. That is, whether the scanner is
+// in the same state at both, apart from where they are.
+func (c Checkpoint) Continues(old Checkpoint) bool {
+	from, to := old.Position(), c.Position()
+	if c.lineStart != old.lineStart {
+		return false
+	}
+	for a, b := c.state.indent, old.state.indent; a != nil || b != nil; a, b = a.next, b.next {
+		if a == nil || b == nil || a.indent != b.indent {
+			return false
+		}
+	}
+
+	if c.failure.index < 0 || old.failure.index < 0 {
+		return c.failure.index == old.failure.index
+	}
+	if c.failure.position() != old.failure.position().Moved(from, to) || len(c.expected) != len(old.expected) {
+		return false
+	}
+	for i := range c.expected {
+		if c.expected[i] != old.expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Moved returns this checkpoint after an edit, given that lexing goes
+// on from to as it did from from, see Continues. The checkpoint must
+// come after from, and counts as having examined all input that was
+// examined to get to to.
+func (c Checkpoint) Moved(from Checkpoint, to Checkpoint) Checkpoint {
+	before, after := from.Position(), to.Position()
+	move := func(st scannerState) scannerState {
+		moved := st.position().Moved(before, after)
+		st.index, st.lineNumber, st.lineIndex = moved.Offset, moved.Line, moved.Column
+		return st
+	}
+
+	c.state = move(c.state)
+	if c.failure.index >= 0 {
+		c.failure = move(c.failure)
+	}
+	c.examined += after.Offset - before.Offset
+	if to.examined > c.examined {
+		c.examined = to.examined
+	}
+	return c
+}
+
+// Moved returns where this position is after the input before it was
+// edited, such that the position from is now at to. The input between
+// from and this position must be unchanged, so columns only move on
+// the line of from.
+func (p Position) Moved(from Position, to Position) Position {
+	if p.Line == from.Line {
+		p.Column += to.Column - from.Column
+	}
+	p.Offset += to.Offset - from.Offset
+	p.Line += to.Line - from.Line
+	return p
+}
+
+// Moved returns a copy of this tree, with all positions moved as by
+// Position.Moved, so that it may be reused after the input before it
+// was edited. The errors of nodes produced by Recover are copied and
+// moved as well, any other Extra is shared with the original tree.
+func (n *LexNode) Moved(from Position, to Position) *LexNode {
+	moved := *n
+	moved.Start = n.Start.Moved(from, to)
+	moved.End = n.End.Moved(from, to)
+	if lexErr, ok := n.Extra.(*LexError); ok && n.Name == "error" {
+		moved.Extra = lexErr.Moved(from, to)
+	}
+	if n.Children != nil {
+		moved.Children = make([]*LexNode, len(n.Children))
+		for i, child := range n.Children {
+			moved.Children[i] = child.Moved(from, to)
+		}
+	}
+	return &moved
+}
+
+// Moved returns a copy of this error, with its position and trace
+// moved as by Position.Moved.
+func (e *LexError) Moved(from Position, to Position) *LexError {
+	moved := *e
+	moved.position = e.position.Moved(from, to)
+	if e.stack != nil {
+		moved.stack = make([]traceFrame, len(e.stack))
+		for i, frame := range e.stack {
+			frame.position = frame.position.Moved(from, to)
+			moved.stack[i] = frame
+		}
+	}
+	return &moved
+}
@@ -0,0 +1,524 @@
+package lexer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GenerateOptions configures Generate. Fields left zero take their
+// default values.
+type GenerateOptions struct {
+	// MaxDepth is how deeply Futures may be nested before every
+	// choice is made to get out of them as soon as possible, and
+	// every repetition is kept to its minimum. The default is 8.
+	MaxDepth int
+
+	// MaxRepeat is how many more times than their minimum both
+	// lexers and regular expressions are repeated at most. The
+	// default is 3.
+	MaxRepeat int
+
+	// AnyRune samples character classes from all of their runes.
+	// Otherwise, printable ASCII is used wherever a class has any.
+	AnyRune bool
+
+	// Attempts is how many sentences may be generated and turned
+	// down before giving up, see Generate. The default is 100.
+	Attempts int
+
+	// Exclude are lexers that are never generated, such as parts of
+	// the grammar that whatever gets the sentences can't handle.
+	// Alternatives that need them are never taken.
+	Exclude []Lexer
+}
+
+// Generate returns a random sentence that root matches completely,
+// without recovering from any errors. The sentence is built by
+// walking the grammar, taking random alternatives and repetitions,
+// and sampling regular expressions. What predicates, keywords and
+// reserved words rule out can only be found out afterwards, so every
+// sentence is lexed by root before it is returned, and another one
+// is generated if it doesn't match. Lexers from other packages are
+// taken to match nothing.
+func Generate(root Lexer, r *rand.Rand, options GenerateOptions) (string, error) {
+	if options.MaxDepth <= 0 {
+		options.MaxDepth = 8
+	}
+	if options.MaxRepeat <= 0 {
+		options.MaxRepeat = 3
+	}
+	if options.Attempts <= 0 {
+		options.Attempts = 100
+	}
+
+	g := &generator{
+		rand:    r,
+		options: options,
+		costs:   make(map[*FutureLexer]int),
+		regexes: make(map[*RegexLexer]*syntax.Regexp),
+		exclude: make(map[Lexer]bool),
+	}
+	for _, l := range options.Exclude {
+		g.exclude[l] = true
+	}
+	g.findCosts(root)
+	if g.cost(root) == infiniteCost {
+		return "", errors.New("lexer: the grammar matches no finite sentence")
+	}
+
+	for attempt := 0; attempt < options.Attempts; attempt++ {
+		g.out, g.lineEnds, g.indents, g.depth, g.failed = g.out[:0], nil, nil, 0, false
+		g.generate(root)
+		g.endLines()
+		if !g.failed && accepts(root, string(g.out)) {
+			return string(g.out), nil
+		}
+	}
+	return "", fmt.Errorf("lexer: none of %d generated sentences matched", options.Attempts)
+}
+
+// accepts returns whether root matches all of text, without errors.
+func accepts(root Lexer, text string) bool {
+	s := NewScanner(text)
+	node, lexErr := root.Lex(s)
+	return lexErr == nil && s.Position().Offset == len(text) && len(node.Errors()) == 0
+}
+
+const infiniteCost = math.MaxInt32
+
+type generator struct {
+	rand    *rand.Rand
+	options GenerateOptions
+
+	// The least depth of Futures needed to get out of each Future
+	costs   map[*FutureLexer]int
+	regexes map[*RegexLexer]*syntax.Regexp
+	exclude map[Lexer]bool
+
+	out      []byte
+	lineEnds []int // where line comments end, see endLines
+	indents  []string
+	depth    int
+	failed   bool // the sentence can't be matched, whatever follows
+}
+
+// findCosts finds the cost of every Future in the grammar, by
+// updating them until none of them changes. Futures that can't be
+// got out of keep an infinite cost.
+func (g *generator) findCosts(root Lexer) {
+	rules := grammarRules(root)
+	for _, rule := range rules {
+		if rule.future != nil {
+			g.costs[rule.future] = infiniteCost
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, rule := range rules {
+			if rule.future == nil || rule.body == nil {
+				continue
+			}
+			if cost := g.cost(rule.body); cost < g.costs[rule.future] {
+				g.costs[rule.future] = cost
+				changed = true
+			}
+		}
+	}
+}
+
+// cost returns the least depth of Futures that generating l takes.
+func (g *generator) cost(l Lexer) int {
+	if g.exclude[l] {
+		return infiniteCost
+	}
+	least := func(lexers []Lexer) int {
+		cost := infiniteCost
+		for _, child := range lexers {
+			if c := g.cost(child); c < cost {
+				cost = c
+			}
+		}
+		return cost
+	}
+
+	switch l := l.(type) {
+	case *FutureLexer:
+		if cost, ok := g.costs[l]; ok && cost != infiniteCost {
+			return cost + 1
+		}
+		return infiniteCost
+	case *OrLexer:
+		return least(l.children)
+	case *RepeatLexer:
+		if l.min <= 0 {
+			return 0
+		}
+		return g.cost(l.child)
+	case *TriviaLexer:
+		if l.allowEmpty {
+			return 0
+		}
+		return least(l.alternatives)
+	case *InterlaceLexer:
+		return g.cost(l.outer)
+	case *RecoverLexer:
+		return g.cost(l.child)
+	case *OperatorsLexer:
+		return g.cost(l.operand)
+	case *PeekLexer, *NotLexer:
+		return 0
+	}
+
+	cost := 0
+	for _, child := range children(l) {
+		if c := g.cost(child); c > cost {
+			cost = c
+		}
+	}
+	return cost
+}
+
+// tooDeep returns whether choices should be made to end the sentence.
+func (g *generator) tooDeep() bool {
+	return g.depth >= g.options.MaxDepth
+}
+
+// choose returns one of the given lexers to generate, at random.
+func (g *generator) choose(lexers []Lexer) Lexer {
+	var candidates []Lexer
+	least := infiniteCost
+	for _, l := range lexers {
+		cost := g.cost(l)
+		switch {
+		case cost == infiniteCost:
+		case g.tooDeep() && cost < least:
+			candidates, least = []Lexer{l}, cost
+		case g.tooDeep() && cost > least:
+		default:
+			candidates = append(candidates, l)
+		}
+	}
+	if len(candidates) == 0 {
+		g.failed = true
+		return nil
+	}
+	return candidates[g.rand.Intn(len(candidates))]
+}
+
+// count returns how many times to repeat something, at random.
+func (g *generator) count(min int, max int) int {
+	if min < 0 {
+		min = 0
+	}
+	if g.tooDeep() {
+		return min
+	}
+	upper := min + g.options.MaxRepeat
+	if max >= 0 && max < upper {
+		upper = max
+	}
+	if upper <= min {
+		return min
+	}
+	return min + g.rand.Intn(upper-min+1)
+}
+
+// truncate removes what was generated after the given length.
+func (g *generator) truncate(length int) {
+	g.out = g.out[:length]
+	for len(g.lineEnds) > 0 && g.lineEnds[len(g.lineEnds)-1] > length {
+		g.lineEnds = g.lineEnds[:len(g.lineEnds)-1]
+	}
+}
+
+// endLines ends the lines of line comments that are followed by
+// anything but a newline, which they would take in otherwise.
+func (g *generator) endLines() {
+	for i := len(g.lineEnds) - 1; i >= 0; i-- {
+		end := g.lineEnds[i]
+		if end < len(g.out) && g.out[end] != '\n' {
+			g.out = append(g.out[:end], append([]byte{'\n'}, g.out[end:]...)...)
+		}
+	}
+}
+
+func (g *generator) atLineStart() bool {
+	return len(g.out) == 0 || g.out[len(g.out)-1] == '\n'
+}
+
+func (g *generator) generate(l Lexer) {
+	if g.failed || l == nil {
+		return
+	}
+	if g.exclude[l] {
+		g.failed = true
+		return
+	}
+
+	switch l := l.(type) {
+	case *AtomLexer:
+		g.out = append(g.out, l.atom...)
+	case *RegexLexer:
+		g.regex(l)
+	case *RuneLexer:
+		g.out = utf8.AppendRune(g.out, l.r)
+	case *RuneClassLexer:
+		g.out = utf8.AppendRune(g.out, g.sampleRune(func(r rune) bool {
+			return unicode.IsOneOf(l.tables, r)
+		}, l.tables))
+	case *OrLexer:
+		g.generate(g.choose(l.children))
+	case *RepeatLexer:
+		for n := g.count(l.min, l.max); n > 0; n-- {
+			g.generate(l.child)
+		}
+	case *InterlaceLexer:
+		g.generate(l.outer)
+		for n := g.count(0, -1); n > 0; n-- {
+			g.generate(l.inner)
+			g.generate(l.outer)
+		}
+	case *FutureLexer:
+		if *l.pointer == nil {
+			g.failed = true
+			return
+		}
+		g.depth++
+		g.generate(*l.pointer)
+		g.depth--
+	case *ReservedLexer:
+		g.reserved(l)
+	case *RecoverLexer:
+		g.generate(l.child)
+	case *PeekLexer, *NotLexer, *CutLexer, *EOFLexer:
+		// These match nothing, which is checked afterwards
+	case *TriviaLexer:
+		min := 0
+		if !l.allowEmpty {
+			min = 1
+		}
+		for n := g.count(min, -1); n > 0; n-- {
+			g.generate(g.choose(l.alternatives))
+		}
+	case *BlockCommentLexer:
+		g.out = append(g.out, l.open...)
+		g.text()
+		g.out = append(g.out, l.close...)
+	case *LineCommentLexer:
+		g.out = append(g.out, l.prefix...)
+		g.text()
+		g.lineEnds = append(g.lineEnds, len(g.out))
+	case *IndentLexer:
+		indent := "  "
+		if len(g.indents) > 0 {
+			indent = g.indents[len(g.indents)-1] + indent
+		}
+		g.indents = append(g.indents, indent)
+		g.indentation()
+	case *SameIndentLexer:
+		g.indentation()
+	case *DedentLexer:
+		if len(g.indents) == 0 {
+			g.failed = true
+			return
+		}
+		g.indents = g.indents[:len(g.indents)-1]
+	case *OperatorsLexer:
+		g.operators(l)
+	default:
+		for _, child := range children(l) {
+			g.generate(child)
+		}
+	}
+}
+
+// indentation writes the indentation of the current block, which
+// must be at the start of a line.
+func (g *generator) indentation() {
+	if !g.atLineStart() {
+		g.failed = true
+		return
+	}
+	if len(g.indents) > 0 {
+		g.out = append(g.out, g.indents[len(g.indents)-1]...)
+	}
+}
+
+// reserved generates the child of l, until it isn't a reserved word.
+func (g *generator) reserved(l *ReservedLexer) {
+	start := len(g.out)
+	for attempt := 0; attempt < g.options.Attempts; attempt++ {
+		g.truncate(start)
+		g.generate(l.child)
+		if g.failed || !l.words[string(g.out[start:])] {
+			return
+		}
+	}
+	g.failed = true
+}
+
+// operators generates operands combined by random operators.
+func (g *generator) operators(l *OperatorsLexer) {
+	tokens := func(ops []Operator) []Lexer {
+		lexers := make([]Lexer, len(ops))
+		for i, op := range ops {
+			lexers[i] = op.Token
+		}
+		return lexers
+	}
+	prefix, infix, postfix := tokens(l.prefix), tokens(l.infix), tokens(l.postfix)
+
+	operand := func() {
+		if len(prefix) > 0 {
+			for n := g.count(0, 1); n > 0; n-- {
+				g.generate(g.choose(prefix))
+				g.generate(l.space)
+			}
+		}
+		g.generate(l.operand)
+		if len(postfix) > 0 {
+			for n := g.count(0, 1); n > 0; n-- {
+				g.generate(l.space)
+				g.generate(g.choose(postfix))
+			}
+		}
+	}
+
+	operand()
+	if len(infix) == 0 {
+		return
+	}
+	for n := g.count(0, -1); n > 0; n-- {
+		g.generate(l.space)
+		g.generate(g.choose(infix))
+		g.generate(l.space)
+		operand()
+	}
+}
+
+// text writes a few random words, for within comments.
+func (g *generator) text() {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	for n := g.count(0, -1); n > 0; n-- {
+		g.out = append(g.out, ' ')
+		for i := g.rand.Intn(6); i >= 0; i-- {
+			g.out = append(g.out, letters[g.rand.Intn(len(letters))])
+		}
+	}
+	g.out = append(g.out, ' ')
+}
+
+// regex writes a random string that the pattern of l matches.
+func (g *generator) regex(l *RegexLexer) {
+	re, ok := g.regexes[l]
+	if !ok {
+		parsed, err := syntax.Parse(l.pattern, syntax.Perl)
+		if err != nil {
+			panic("lexer: invalid regex: " + err.Error())
+		}
+		re = parsed.Simplify()
+		g.regexes[l] = re
+	}
+
+	start := len(g.out)
+	for attempt := 0; attempt < g.options.Attempts; attempt++ {
+		g.truncate(start)
+		g.sampleRegex(re)
+		if l.allowEmpty || len(g.out) > start {
+			return
+		}
+	}
+	g.failed = true
+}
+
+func (g *generator) sampleRegex(re *syntax.Regexp) {
+	repeat := func(min int, max int) {
+		for n := g.count(min, max); n > 0; n-- {
+			g.sampleRegex(re.Sub[0])
+		}
+	}
+
+	switch re.Op {
+	case syntax.OpLiteral:
+		g.out = append(g.out, string(re.Rune)...)
+	case syntax.OpCharClass:
+		g.out = utf8.AppendRune(g.out, g.sampleRune(func(r rune) bool {
+			for i := 0; i+1 < len(re.Rune); i += 2 {
+				if re.Rune[i] <= r && r <= re.Rune[i+1] {
+					return true
+				}
+			}
+			return false
+		}, []*unicode.RangeTable{classTable(re.Rune)}))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		g.out = utf8.AppendRune(g.out, g.sampleRune(func(r rune) bool {
+			return r != '\n' || re.Op == syntax.OpAnyChar
+		}, unicode.GraphicRanges))
+	case syntax.OpCapture:
+		g.sampleRegex(re.Sub[0])
+	case syntax.OpStar:
+		repeat(0, -1)
+	case syntax.OpPlus:
+		repeat(1, -1)
+	case syntax.OpQuest:
+		repeat(0, 1)
+	case syntax.OpRepeat:
+		repeat(re.Min, re.Max)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			g.sampleRegex(sub)
+		}
+	case syntax.OpAlternate:
+		g.sampleRegex(re.Sub[g.rand.Intn(len(re.Sub))])
+	}
+}
+
+// sampleRune returns a random rune for which match holds, taken from
+// printable ASCII where possible, unless AnyRune is set, or from the
+// given tables otherwise.
+func (g *generator) sampleRune(match func(r rune) bool, tables []*unicode.RangeTable) rune {
+	if !g.options.AnyRune {
+		var ascii []rune
+		for r := rune(' '); r <= '~'; r++ {
+			if match(r) {
+				ascii = append(ascii, r)
+			}
+		}
+		if len(ascii) > 0 {
+			return ascii[g.rand.Intn(len(ascii))]
+		}
+	}
+
+	var ranges []unicode.Range32
+	for _, table := range tables {
+		for _, r := range table.R16 {
+			ranges = append(ranges, unicode.Range32{Lo: uint32(r.Lo), Hi: uint32(r.Hi), Stride: uint32(r.Stride)})
+		}
+		ranges = append(ranges, table.R32...)
+	}
+	for attempt := 0; attempt < g.options.Attempts && len(ranges) > 0; attempt++ {
+		r := ranges[g.rand.Intn(len(ranges))]
+		sampled := rune(r.Lo + r.Stride*uint32(g.rand.Intn(int((r.Hi-r.Lo)/r.Stride)+1)))
+		if utf8.ValidRune(sampled) && match(sampled) {
+			return sampled
+		}
+	}
+	g.failed = true
+	return utf8.RuneError
+}
+
+// classTable turns the ranges of a character class into a table.
+func classTable(ranges []rune) *unicode.RangeTable {
+	table := &unicode.RangeTable{}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		table.R32 = append(table.R32, unicode.Range32{
+			Lo: uint32(ranges[i]), Hi: uint32(ranges[i+1]), Stride: 1,
+		})
+	}
+	return table
+}
@@ -0,0 +1,96 @@
+package lexer
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+	"unicode"
+)
+
+func TestGenerate(t *testing.T) {
+
+	// expr = "(" expr ")" | "x", which is only nested so deep
+	var expr Lexer
+	lExpr := Future(&expr, "expr")
+	expr = Or(And(Atom("("), lExpr, Atom(")")), Atom("x"))
+
+	// never = "a" never, which can't end
+	var never Lexer
+	lNever := Future(&never, "never")
+	never = And(Atom("a"), lNever)
+
+	excluded := Atom("b")
+	word := Regex("[a-z]+", false)
+	block := And(Atom(":\n"), Indent(), Interlace(word, And(Atom("\n"), SameIndent())), Dedent())
+
+	tests := []struct {
+		root     Lexer
+		options  GenerateOptions
+		expected string   // a pattern every sentence must match
+		all      []string // sentences that must all be generated
+		err      string
+	}{
+		{Or(Atom("a"), Atom("b"), Atom("c")), GenerateOptions{}, `^[abc]$`, []string{"a", "b", "c"}, ""},
+		{Repeat(Atom("a"), 1, 3), GenerateOptions{}, `^a{1,3}$`, []string{"a", "aa", "aaa"}, ""},
+		{lExpr, GenerateOptions{MaxDepth: 2}, `^(x|\(x\))$`, []string{"x", "(x)"}, ""},
+		{Regex(`[0-9]{2,3}-(ab|c)+`, false), GenerateOptions{MaxRepeat: 1}, `^[0-9]{2,3}-(ab|c){1,2}$`, nil, ""},
+		{RuneClass(unicode.Greek), GenerateOptions{AnyRune: true}, `^\p{Greek}$`, nil, ""},
+		{And(Keyword("let", word), Regex(" +", false), Reserved(word, "let", "x", "y")),
+			GenerateOptions{}, `^let +[a-z]+$`, nil, ""},
+		{And(Atom("/*"), BlockComment("<", ">"), Atom("*/"), LineComment("#"), EOF()),
+			GenerateOptions{}, `^/\*< [a-z ]*>\*/# [a-z ]*$`, nil, ""},
+		{And(Atom("x"), Trivia(Regex("[ \n]+", false), true, LineComment("#")), Atom("y")),
+			GenerateOptions{}, `^x([ \n]|# [a-z ]*\n)*y$`, nil, ""},
+		{block, GenerateOptions{MaxRepeat: 1}, `^:\n  [a-z]+(\n  [a-z]+)?$`, nil, ""},
+		{Operators(Atom("x"), Regex(" ?", true), PrefixOp(2, Atom("-")), InfixOp(1, LeftAssoc, Atom("+"))),
+			GenerateOptions{}, `^-? ?x( ?\+ ?-? ?x)*$`, nil, ""},
+		{Or(Atom("a"), excluded, Atom("c")), GenerateOptions{Exclude: []Lexer{excluded}}, `^[ac]$`, []string{"a", "c"}, ""},
+
+		// Grammars that can't be generated from
+		{And(Atom("a"), Not(Atom("b")), Atom("b")), GenerateOptions{}, "", nil,
+			"lexer: none of 100 generated sentences matched"},
+		{lNever, GenerateOptions{}, "", nil, "lexer: the grammar matches no finite sentence"},
+		{And(Atom("a"), Peek(Atom("b"))), GenerateOptions{Attempts: 5}, "", nil,
+			"lexer: none of 5 generated sentences matched"},
+		{And(Atom("a"), Future(new(Lexer), "missing")), GenerateOptions{}, "", nil,
+			"lexer: the grammar matches no finite sentence"},
+		{And(Atom("a"), excluded), GenerateOptions{Exclude: []Lexer{excluded}}, "", nil,
+			"lexer: the grammar matches no finite sentence"},
+	}
+
+	for i, tt := range tests {
+		r := rand.New(rand.NewSource(int64(i)))
+		if tt.err != "" {
+			_, err := Generate(tt.root, r, tt.options)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("TestGenerate[%d]: expected error=%q got=%v", i, tt.err, err)
+			}
+			continue
+		}
+
+		pattern := regexp.MustCompile(tt.expected)
+		generated := make(map[string]bool)
+		for n := 0; n < 50; n++ {
+			sentence, err := Generate(tt.root, r, tt.options)
+			if err != nil {
+				t.Fatalf("TestGenerate[%d]: unexpected error=%s", i, err.Error())
+			}
+			if !pattern.MatchString(sentence) {
+				t.Fatalf("TestGenerate[%d]: expected match for %s, got=%q", i, tt.expected, sentence)
+			}
+			generated[sentence] = true
+		}
+		for _, sentence := range tt.all {
+			if !generated[sentence] {
+				t.Fatalf("TestGenerate[%d]: expected %q to be generated, got=%v", i, sentence, generated)
+			}
+		}
+	}
+
+	// The same seed gives the same sentence
+	first, _ := Generate(lExpr, rand.New(rand.NewSource(42)), GenerateOptions{})
+	second, _ := Generate(lExpr, rand.New(rand.NewSource(42)), GenerateOptions{})
+	if first != second {
+		t.Fatalf("TestGenerate: expected the same sentence, got=%q and %q", first, second)
+	}
+}
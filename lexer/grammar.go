@@ -0,0 +1,531 @@
+package lexer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CompileGrammar compiles a grammar written in a PEG-like notation
+// into lexers, one for every rule, by name. A grammar is a list of
+// rules of the form
+//
+//	name = expression
+//
+// optionally followed by a semicolon. Expressions are made of:
+//
+//	"text"          Atom, with Go escape sequences
+//	/pattern/       Regex, which must match something; the pattern
+//	                may not start with a space
+//	name            the rule of that name, through a Future
+//	label:e         Group
+//	a b             And
+//	a / b, a | b    Or
+//	e* e+ e?        Repeat, at least once, Optional
+//	&e !e           Peek, Not
+//	~               Cut
+//	(e)             a node of its own, so that a label on it won't
+//	                rename the node of a rule it refers to
+//	@name(args)     a builtin lexer, see below
+//
+// Comments start with # or //, or are enclosed in /* and */. The
+// builtins are @eof, @indent, @sameindent, @dedent, @peek(e), @not(e),
+// @ignore(e), @interlace(outer, inner), @recover(e, sync),
+// @keyword("word", wordChar), @reserved(e, "word", ...),
+// @comment("open", "close"), @linecomment("prefix"),
+// @trivia(space, comment, ...) which may be empty,
+// @sometrivia(space, comment, ...) which may not,
+// @runeclass("Lu", "Greek", ...) and
+// @operators(operand, space, row, ...), where each row is one of
+// @infix(precedence, "left" | "right" | "none", token),
+// @prefix(precedence, token) or @postfix(precedence, token).
+//
+// Errors in the grammar are returned with their position in text.
+func CompileGrammar(text string) (map[string]Lexer, *LexError) {
+	return CompileGrammarWith(text, nil)
+}
+
+// CompileGrammarWith compiles a grammar like CompileGrammar, in which
+// rules may also refer to the given external lexers by name.
+func CompileGrammarWith(text string, externals map[string]Lexer) (map[string]Lexer, *LexError) {
+	s := NewScanner(text)
+	s.EnableMemo()
+	tree, lexErr := grammarLexer.Lex(s)
+	if lexErr != nil {
+		return nil, lexErr
+	}
+
+	c := &grammarCompiler{
+		rules:     make(map[string]*Lexer),
+		lexers:    make(map[string]Lexer),
+		externals: externals,
+	}
+
+	// Declare all rules first, so that they can refer to each other
+	ruleNodes := tree.GroupNodes("rule")
+	for _, node := range ruleNodes {
+		name := node.GroupNode("name").Value
+		if _, ok := c.rules[name]; ok {
+			return nil, grammarErr(node, "Duplicate rule: %s", name)
+		}
+		if _, ok := externals[name]; ok {
+			return nil, grammarErr(node, "Rule shadows external lexer: %s", name)
+		}
+		c.rules[name] = new(Lexer)
+		c.lexers[name] = Future(c.rules[name], name)
+	}
+
+	for _, node := range ruleNodes {
+		lex, lexErr := c.choice(node.GroupNode("choice"))
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		*c.rules[node.GroupNode("name").Value] = lex
+	}
+	return c.lexers, nil
+}
+
+func grammarErr(node *LexNode, format string, args ...interface{}) *LexError {
+	return &LexError{
+		err:      fmt.Sprintf(format, args...),
+		position: node.Start,
+		fixed:    true,
+	}
+}
+
+// grammarLexer lexes the notation accepted by CompileGrammar.
+var grammarLexer = newGrammarLexer()
+
+func newGrammarLexer() Lexer {
+	space := Trivia(Regex(`[\n\t\r ]+`, false), true,
+		LineComment("#"), LineComment("//"), BlockComment("/*", "*/"))
+	ident := Group("name", Regex(`[A-Za-z_][A-Za-z0-9_]*`, false))
+
+	var choice, primary Lexer
+	lChoice := Future(&choice, "choice")
+	lPrimary := Future(&primary, "primary")
+
+	arg := Group("arg", Or(
+		Group("number", Regex(`-?[0-9]+`, false)),
+		lChoice,
+	))
+	primary = Group("primary", Or(
+		Group("label", And(ident, Atom(":"), lPrimary)),
+		Group("call", And(
+			Atom("@"), ident,
+			Optional(And(
+				Atom("("), space,
+				Group("args", Interlace(arg, And(space, Atom(","), space))),
+				space, Atom(")"),
+			)),
+		)),
+		Group("string", Regex(`"(?:[^"\\\n]|\\.)*"`, false)),
+		Group("regex", Regex(`/(?:[^ /\\\n]|\\.)(?:[^/\\\n]|\\.)*/`, false)),
+		Group("cut", Atom("~")),
+		Group("paren", And(Atom("("), space, lChoice, space, Atom(")"))),
+		Group("ref", And(ident, Not(And(space, Atom("="))))),
+	))
+
+	// Predicates and quantifiers are always there, if empty, so that
+	// they can't be confused with those of a nested expression.
+	suffix := Group("suffix", And(
+		lPrimary,
+		Group("quantifier", Regex(`[*+?]?`, true)),
+	))
+	prefix := Group("prefix", And(
+		Group("predicate", Regex(`[&!]?`, true)),
+		suffix,
+	))
+	sequence := Group("sequence", Interlace(prefix, space))
+	choice = Group("choice", Interlace(
+		sequence,
+		And(space, Or(Atom("/"), Atom("|")), space),
+	))
+
+	rule := Group("rule", And(
+		ident, space, Atom("="), Cut(), space,
+		lChoice, Optional(And(space, Atom(";"))),
+	))
+	return And(space, Repeat(And(rule, space), 0, -1), EOF())
+}
+
+type grammarCompiler struct {
+	rules     map[string]*Lexer
+	lexers    map[string]Lexer
+	externals map[string]Lexer
+}
+
+func (c *grammarCompiler) choice(node *LexNode) (Lexer, *LexError) {
+	var alternatives []Lexer
+	for _, sequence := range node.GroupNodes("sequence") {
+		lex, lexErr := c.sequence(sequence)
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		alternatives = append(alternatives, lex)
+	}
+
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return Or(alternatives...), nil
+}
+
+func (c *grammarCompiler) sequence(node *LexNode) (Lexer, *LexError) {
+	var items []Lexer
+	for _, prefix := range node.GroupNodes("prefix") {
+		lex, lexErr := c.prefix(prefix)
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		items = append(items, lex)
+	}
+
+	if len(items) == 1 {
+		return items[0], nil
+	}
+	return And(items...), nil
+}
+
+func (c *grammarCompiler) prefix(node *LexNode) (Lexer, *LexError) {
+	lex, lexErr := c.suffix(node.GroupNode("suffix"))
+	if lexErr != nil {
+		return nil, lexErr
+	}
+
+	switch node.GroupNode("predicate").Value {
+	case "&":
+		return Peek(lex), nil
+	case "!":
+		return Not(lex), nil
+	}
+	return lex, nil
+}
+
+func (c *grammarCompiler) suffix(node *LexNode) (Lexer, *LexError) {
+	lex, lexErr := c.primary(node.GroupNode("primary"))
+	if lexErr != nil {
+		return nil, lexErr
+	}
+
+	switch node.GroupNode("quantifier").Value {
+	case "*":
+		return Repeat(lex, 0, -1), nil
+	case "+":
+		return Repeat(lex, 1, -1), nil
+	case "?":
+		return Optional(lex), nil
+	}
+	return lex, nil
+}
+
+func (c *grammarCompiler) primary(node *LexNode) (Lexer, *LexError) {
+	node = node.Children[0]
+	switch node.GroupName {
+
+	case "label":
+		lex, lexErr := c.primary(node.GroupNode("primary"))
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		return Group(node.GroupNode("name").Value, lex), nil
+
+	case "call":
+		return c.call(node)
+
+	case "string":
+		str, lexErr := c.unquote(node)
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		return Atom(str), nil
+
+	case "regex":
+		pattern := node.Value[1 : len(node.Value)-1]
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, grammarErr(node, "Invalid regex: %s", err.Error())
+		}
+		return Regex(pattern, false), nil
+
+	case "cut":
+		return Cut(), nil
+
+	case "paren":
+		lex, lexErr := c.choice(node.GroupNode("choice"))
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		switch lex.(type) {
+		case *AndLexer, *OrLexer:
+			return lex, nil
+		}
+		return And(lex), nil
+	}
+
+	// Anything else refers to a rule, or an external lexer
+	if lex, ok := c.lexers[node.Value]; ok {
+		return lex, nil
+	}
+	if lex, ok := c.externals[node.Value]; ok {
+		return lex, nil
+	}
+	return nil, grammarErr(node, "Undefined rule: %s", node.Value)
+}
+
+func (c *grammarCompiler) unquote(node *LexNode) (string, *LexError) {
+	str, err := strconv.Unquote(node.Value)
+	if err != nil || !strings.HasPrefix(node.Value, `"`) {
+		return "", grammarErr(node, "Expected string, got: %s", node.Value)
+	}
+	return str, nil
+}
+
+// call compiles a call to a builtin lexer.
+func (c *grammarCompiler) call(node *LexNode) (Lexer, *LexError) {
+	name := node.GroupNode("name").Value
+	var args []*LexNode
+	if argsNode := node.GroupNode("args"); argsNode != nil {
+		args = argsNode.GroupNodes("arg")
+	}
+
+	// Check the number of arguments, where max < 0 means any number
+	arity := func(min, max int) *LexError {
+		if len(args) < min || max >= 0 && len(args) > max {
+			return grammarErr(node, "Wrong number of arguments for @%s: %d", name, len(args))
+		}
+		return nil
+	}
+	lexers := func(args []*LexNode) ([]Lexer, *LexError) {
+		lexers := make([]Lexer, len(args))
+		for i, arg := range args {
+			lex, lexErr := c.lexer(arg)
+			if lexErr != nil {
+				return nil, lexErr
+			}
+			lexers[i] = lex
+		}
+		return lexers, nil
+	}
+	strs := func(args []*LexNode) ([]string, *LexError) {
+		strs := make([]string, len(args))
+		for i, arg := range args {
+			str, lexErr := c.unquote(arg)
+			if lexErr != nil {
+				return nil, lexErr
+			}
+			strs[i] = str
+		}
+		return strs, nil
+	}
+
+	switch name {
+
+	// Builtins without arguments
+	case "eof", "indent", "sameindent", "dedent":
+		if lexErr := arity(0, 0); lexErr != nil {
+			return nil, lexErr
+		}
+		return map[string]func() Lexer{
+			"eof":        EOF,
+			"indent":     Indent,
+			"sameindent": SameIndent,
+			"dedent":     Dedent,
+		}[name](), nil
+
+	// Builtins taking lexers
+	case "peek", "not", "ignore", "interlace", "recover", "trivia", "sometrivia":
+		var lexErr *LexError
+		switch name {
+		case "peek", "not", "ignore":
+			lexErr = arity(1, 1)
+		case "interlace", "recover":
+			lexErr = arity(2, 2)
+		default:
+			lexErr = arity(1, -1)
+		}
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		lexs, lexErr := lexers(args)
+		if lexErr != nil {
+			return nil, lexErr
+		}
+
+		switch name {
+		case "peek":
+			return Peek(lexs[0]), nil
+		case "not":
+			return Not(lexs[0]), nil
+		case "ignore":
+			return Ignore(lexs[0]), nil
+		case "interlace":
+			return Interlace(lexs[0], lexs[1]), nil
+		case "recover":
+			return Recover(lexs[0], lexs[1]), nil
+		}
+		return Trivia(lexs[0], name == "trivia", lexs[1:]...), nil
+
+	// Builtins taking strings
+	case "comment", "linecomment", "runeclass":
+		var lexErr *LexError
+		switch name {
+		case "comment":
+			lexErr = arity(2, 2)
+		case "linecomment":
+			lexErr = arity(1, 1)
+		default:
+			lexErr = arity(1, -1)
+		}
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		words, lexErr := strs(args)
+		if lexErr != nil {
+			return nil, lexErr
+		}
+
+		switch name {
+		case "comment":
+			return BlockComment(words[0], words[1]), nil
+		case "linecomment":
+			return LineComment(words[0]), nil
+		}
+		tables := make([]*unicode.RangeTable, len(words))
+		for i, word := range words {
+			if tables[i] = unicodeTable(word); tables[i] == nil {
+				return nil, grammarErr(args[i], "Unknown Unicode class: %s", word)
+			}
+		}
+		return RuneClass(tables...), nil
+
+	case "keyword":
+		if lexErr := arity(2, 2); lexErr != nil {
+			return nil, lexErr
+		}
+		word, lexErr := c.unquote(args[0])
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		wordChar, lexErr := c.lexer(args[1])
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		return Keyword(word, wordChar), nil
+
+	case "reserved":
+		if lexErr := arity(1, -1); lexErr != nil {
+			return nil, lexErr
+		}
+		lex, lexErr := c.lexer(args[0])
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		words, lexErr := strs(args[1:])
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		return Reserved(lex, words...), nil
+
+	case "operators":
+		if lexErr := arity(2, -1); lexErr != nil {
+			return nil, lexErr
+		}
+		lexs, lexErr := lexers(args[:2])
+		if lexErr != nil {
+			return nil, lexErr
+		}
+		var table []Operator
+		for _, arg := range args[2:] {
+			op, lexErr := c.operator(arg)
+			if lexErr != nil {
+				return nil, lexErr
+			}
+			table = append(table, op)
+		}
+		return Operators(lexs[0], lexs[1], table...), nil
+
+	case "infix", "prefix", "postfix":
+		return nil, grammarErr(node, "@%s may only be used in @operators", name)
+	}
+
+	return nil, grammarErr(node, "Unknown builtin: @%s", name)
+}
+
+// lexer compiles an argument to a builtin that must be a lexer.
+func (c *grammarCompiler) lexer(arg *LexNode) (Lexer, *LexError) {
+	choice := arg.GroupNode("choice")
+	if choice == nil {
+		return nil, grammarErr(arg, "Expected lexer, got: %s", arg.Value)
+	}
+	return c.choice(choice)
+}
+
+// operator compiles a row in the table given to @operators.
+func (c *grammarCompiler) operator(arg *LexNode) (Operator, *LexError) {
+	call := arg.GroupNode("call")
+	if call == nil || call.Value != arg.Value {
+		return Operator{}, grammarErr(arg, "Expected operator, got: %s", arg.Value)
+	}
+
+	var args []*LexNode
+	if argsNode := call.GroupNode("args"); argsNode != nil {
+		args = argsNode.GroupNodes("arg")
+	}
+	name := call.GroupNode("name").Value
+	count := 2
+	if name == "infix" {
+		count = 3
+	}
+	if name != "infix" && name != "prefix" && name != "postfix" {
+		return Operator{}, grammarErr(arg, "Expected operator, got: %s", arg.Value)
+	}
+	if len(args) != count {
+		return Operator{}, grammarErr(call, "Wrong number of arguments for @%s: %d", name, len(args))
+	}
+
+	precedence, err := strconv.Atoi(args[0].Value)
+	if err != nil || args[0].GroupNode("number") == nil {
+		return Operator{}, grammarErr(args[0], "Expected precedence, got: %s", args[0].Value)
+	}
+	token, lexErr := c.lexer(args[count-1])
+	if lexErr != nil {
+		return Operator{}, lexErr
+	}
+
+	switch name {
+	case "prefix":
+		return PrefixOp(precedence, token), nil
+	case "postfix":
+		return PostfixOp(precedence, token), nil
+	}
+
+	assoc, lexErr := c.unquote(args[1])
+	if lexErr != nil {
+		return Operator{}, lexErr
+	}
+	associativity := map[string]Associativity{
+		"left":  LeftAssoc,
+		"right": RightAssoc,
+		"none":  NonAssoc,
+	}
+	if _, ok := associativity[assoc]; !ok {
+		return Operator{}, grammarErr(args[1], "Unknown associativity: %s", assoc)
+	}
+	return InfixOp(precedence, associativity[assoc], token), nil
+}
+
+// unicodeTable looks up a Unicode category, script or property.
+func unicodeTable(name string) *unicode.RangeTable {
+	for _, tables := range []map[string]*unicode.RangeTable{
+		unicode.Categories,
+		unicode.Scripts,
+		unicode.Properties,
+	} {
+		if table, ok := tables[name]; ok {
+			return table
+		}
+	}
+	return nil
+}
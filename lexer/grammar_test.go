@@ -0,0 +1,109 @@
+package lexer
+
+import (
+	"testing"
+)
+
+func TestCompileGrammar(t *testing.T) {
+
+	tests := []struct {
+		grammar string
+		rule    string
+		input   string
+		value   string
+		err     string
+	}{
+		// Sequences, choices and repetition
+		{`a = "x" "y"`, "a", "xy", "xy", ""},
+		{`a = "x" / "y" | "z"`, "a", "z", "z", ""},
+		{`a = "x"* "y"+ "z"?`, "a", "xxyy", "xxyy", ""},
+		{`a = ("x" "y")+ @eof`, "a", "xyxy", "xyxy", ""},
+		{`a = ("x" "y")+ @eof`, "a", "xyx", "", `1:4: expected "y"`},
+		{`a = l:("x" "y"*) @eof`, "a", "xx", "", `1:2: expected one of "y", end of input`},
+
+		// Regexes, escapes and comments
+		{`a = /[0-9]+/ "\n"`, "a", "123\n", "123\n", ""},
+		{"# comment\na = /\\/+/ // comment\n/* b = x */", "a", "//", "//", ""},
+		{`a = num:/[0-9]+/`, "a", "x", "", "1:1: expected num"},
+
+		// Rules refer to each other, in any order, even recursively
+		{`sum = sum "+" num / num; num = /[0-9]+/`, "sum", "1+2+3", "1+2+3", ""},
+		{"list = \"(\" item* \")\"\nitem = list / /[a-z]/", "list", "(a(b)c)", "(a(b)c)", ""},
+
+		// Predicates and cuts
+		{`a = !"x" /[a-z]/`, "a", "y", "y", ""},
+		{`a = !"x" /[a-z]/`, "a", "x", "", `1:1: Unexpected ATOM("x")`},
+		{`a = &"x" /[a-z]+/`, "a", "xy", "xy", ""},
+		{`a = "x" ~ "y" / "xz"`, "a", "xz", "", `1:2: expected "y"`},
+
+		// Builtins
+		{`a = @keyword("if", /[a-z]/) " "`, "a", "if ", "if ", ""},
+		{`a = @keyword("if", /[a-z]/)`, "a", "iffy", "", "1:3: Unexpected REGEX(/[a-z]/)"},
+		{`a = @reserved(/[a-z]+/, "if")`, "a", "if", "", "1:1: expected non-reserved word"},
+		{`a = @runeclass("Greek")+`, "a", "λx", "λ", ""},
+		{`a = "x" @trivia(/[ ]+/, @comment("(*", "*)")) "y"`, "a", "x (* (* *) *) y", "x (* (* *) *) y", ""},
+		{`a = @interlace(/[a-z]/, ",")`, "a", "a,b,c", "a,b,c", ""},
+		{`a = @operators(/[0-9]/, " "?, @infix(1, "left", "-"), @prefix(2, "-"))`,
+			"a", "1 - -2 - 3", "1 - -2 - 3", ""},
+
+		// Errors in the grammar itself
+		{`a = `, "a", "", "", "1:5: expected one of name, \"@\", string, regex, cut, \"(\""},
+		{"a = \"x\"\nb = c", "a", "", "", "2:5: Undefined rule: c"},
+		{"a = \"x\"\n  a = \"y\"", "a", "", "", "2:3: Duplicate rule: a"},
+		{`a = /x(/`, "a", "", "", "1:5: Invalid regex: error parsing regexp: missing closing ): `x(`"},
+		{`a = @foo`, "a", "", "", "1:5: Unknown builtin: @foo"},
+		{`a = @eof("x")`, "a", "", "", "1:5: Wrong number of arguments for @eof: 1"},
+		{`a = @keyword(b, "x")`, "a", "", "", "1:14: Expected string, got: b"},
+		{`a = @runeclass("Nope")`, "a", "", "", "1:16: Unknown Unicode class: Nope"},
+		{`a = @operators("x", "y", "z")`, "a", "", "", "1:26: Expected operator, got: \"z\""},
+		{`a = @operators("x", "y", @infix(1, "up", "+"))`, "a", "", "", "1:36: Unknown associativity: up"},
+	}
+
+	for i, tt := range tests {
+		rules, err := CompileGrammar(tt.grammar)
+		if err != nil {
+			if err.Error() != tt.err {
+				t.Fatalf("TestCompileGrammar[%d]: expected error=%q got=%q",
+					i, tt.err, err.Error())
+			}
+			continue
+		}
+
+		s := NewScanner(tt.input)
+		s.EnableMemo()
+		node, err := rules[tt.rule].Lex(s)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("TestCompileGrammar[%d]: expected error=%q got=%v",
+					i, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestCompileGrammar[%d]: unexpected error=%s", i, err.Error())
+		}
+		if node.Value != tt.value {
+			t.Fatalf("TestCompileGrammar[%d]: expected value=%q got=%q",
+				i, tt.value, node.Value)
+		}
+	}
+}
+
+func TestCompileGrammarWith(t *testing.T) {
+	externals := map[string]Lexer{
+		"digit": Regex("[0-9]", false),
+	}
+	rules, err := CompileGrammarWith(`number = digit+`, externals)
+	if err != nil {
+		t.Fatalf("TestCompileGrammarWith: unexpected error=%s", err.Error())
+	}
+	node, err := rules["number"].Lex(NewScanner("42"))
+	if err != nil || node.Value != "42" {
+		t.Fatalf("TestCompileGrammarWith: expected value=%q got=%v", "42", node)
+	}
+
+	_, err = CompileGrammarWith(`digit = "0"`, externals)
+	if err == nil || err.Error() != "1:1: Rule shadows external lexer: digit" {
+		t.Fatalf("TestCompileGrammarWith: expected shadowing error, got=%v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// indentLevel is an entry in the indentation stack of a scanner.
+// Levels are never modified once created, so that saved scanner
+// states can share them.
+type indentLevel struct {
+	indent string
+	next   *indentLevel
+}
+
+func (l *indentLevel) String() string {
+	if l == nil {
+		return ""
+	}
+	return l.indent
+}
+
+// atLineStart returns whether the scanner is at the start of a line.
+func (s *Scanner) atLineStart() bool {
+	return s.index == s.lines[s.lineNumber-1]
+}
+
+// indentAt returns the spaces and tabs starting at the given offset.
+func (s *Scanner) indentAt(offset int) string {
+	end := offset
+	for s.fill(end-s.index+1) && (s.input[end-s.base] == ' ' || s.input[end-s.base] == '\t') {
+		end++
+	}
+	return s.slice(offset, end)
+}
+
+// checkIndent returns an error if the indentation at the given
+// offset can't be compared to that of the current block, because
+// it mixes tabs and spaces in a different way.
+func (s *Scanner) checkIndent(offset int, indent string, lex Lexer) *LexError {
+	current := s.indent.String()
+	mixed := strings.Contains(indent, " ") && strings.Contains(indent, "\t")
+	if !mixed && (strings.HasPrefix(indent, current) || strings.HasPrefix(current, indent)) {
+		return nil
+	}
+
+	e := &LexError{
+		err:       "Mixed tabs and spaces in indentation",
+		position:  s.positionAt(offset),
+		fixed:     true,
+		committed: true,
+	}
+	e.Trace(s, lex)
+	return e
+}
+
+type IndentLexer struct{}
+
+// Indent matches the indentation at the start of a line, if it is
+// deeper than that of the current block, and starts a new block at
+// that indentation. The block lasts until the matching Dedent, and
+// is undone by backtracking like any other input consumed.
+func Indent() Lexer {
+	return &IndentLexer{}
+}
+
+func (p *IndentLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	if !s.atLineStart() {
+		s.expect("indented block")
+		return nil, err(s, "Expected indentation at start of line", p)
+	}
+
+	indent := s.indentAt(s.index)
+	if lexErr := s.checkIndent(s.index, indent, p); lexErr != nil {
+		return nil, lexErr
+	}
+	if len(indent) <= len(s.indent.String()) {
+		s.expect("indented block")
+		return nil, err(s, "Expected indentation", p)
+	}
+
+	s.Forward(len(indent))
+	s.indent = &indentLevel{indent: indent, next: s.indent}
+	return &LexNode{
+		Name:  "indent",
+		Value: indent,
+		Start: start,
+		End:   s.Position(),
+	}, nil
+}
+
+func (p *IndentLexer) ToString() string {
+	return "INDENT()"
+}
+
+type SameIndentLexer struct{}
+
+// SameIndent matches the indentation at the start of a line, if it
+// is the same as that of the current block.
+func SameIndent() Lexer {
+	return &SameIndentLexer{}
+}
+
+func (p *SameIndentLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	if !s.atLineStart() {
+		s.expect("same indentation")
+		return nil, err(s, "Expected indentation at start of line", p)
+	}
+
+	indent := s.indentAt(s.index)
+	if lexErr := s.checkIndent(s.index, indent, p); lexErr != nil {
+		return nil, lexErr
+	}
+	if indent != s.indent.String() {
+		s.expect("same indentation")
+		return nil, err(s, "Expected same indentation", p)
+	}
+
+	s.Forward(len(indent))
+	return &LexNode{
+		Name:  "indent",
+		Value: indent,
+		Start: start,
+		End:   s.Position(),
+	}, nil
+}
+
+func (p *SameIndentLexer) ToString() string {
+	return "SAMEINDENT()"
+}
+
+type DedentLexer struct{}
+
+// Dedent ends the current block, if the next line that isn't blank
+// is indented less deeply than it, or there are no more lines. It
+// doesn't consume any input, so it can be used right after the last
+// line of a block, as well as at the start of the next line.
+func Dedent() Lexer {
+	return &DedentLexer{}
+}
+
+func (p *DedentLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	if s.indent == nil {
+		return nil, err(s, "Dedent outside of indented block", p)
+	}
+
+	// Skip trailing space and blank lines, to find the next line
+	offset := s.index
+	lineStart := -1
+	if s.atLineStart() {
+		lineStart = offset
+	}
+	for {
+		offset += len(s.indentAt(offset))
+		if !s.fill(offset-s.index+1) || s.input[offset-s.base] != '\n' {
+			break
+		}
+		offset++
+		lineStart = offset
+	}
+
+	indent := ""
+	if s.fill(offset - s.index + 1) {
+		if lineStart < 0 {
+			s.expect("end of line")
+			return nil, err(s, "Expected end of line", p)
+		}
+		indent = s.slice(lineStart, offset)
+		if lexErr := s.checkIndent(lineStart, indent, p); lexErr != nil {
+			return nil, lexErr
+		}
+	}
+
+	if len(indent) >= len(s.indent.String()) {
+		s.expect("end of block")
+		return nil, err(s, fmt.Sprintf("Expected indentation less than %q", s.indent), p)
+	}
+
+	s.indent = s.indent.next
+	return &LexNode{
+		Name:  "dedent",
+		Start: start,
+		End:   start,
+	}, nil
+}
+
+func (p *DedentLexer) ToString() string {
+	return "DEDENT()"
+}
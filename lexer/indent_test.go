@@ -0,0 +1,85 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+)
+
+// renderBlocks renders nested blocks lexed by the grammar in
+// TestIndentLexer as e.g. "a[b c[d]]".
+func renderBlocks(node *LexNode) string {
+	var items []string
+	for _, item := range node.GroupNodes("item") {
+		str := item.GroupNode("word").Value
+		if block := item.GroupNode("block"); block != nil {
+			str += "[" + renderBlocks(block) + "]"
+		}
+		items = append(items, str)
+	}
+	return strings.Join(items, " ")
+}
+
+func TestIndentLexer(t *testing.T) {
+
+	var block Lexer
+	lBlock := Future(&block, "block")
+	lNewline := Group("newline", Regex("(?:[ \t]*\n)+", false))
+	lWord := Group("word", Regex("[a-z]+", false))
+	lItem := Group("item", Or(
+		And(lWord, Atom(":"), lNewline, lBlock),
+		lWord,
+	))
+	block = Group("block", And(
+		Indent(),
+		Interlace(lItem, And(lNewline, SameIndent())),
+		Dedent(),
+	))
+	lProgram := Group("block", And(
+		Interlace(lItem, lNewline),
+		Optional(lNewline),
+		EOF(),
+	))
+
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{"a\nb", "a b", ""},
+		{"a:\n  b\n  c\nd", "a[b c] d", ""},
+		{"a:\n  b:\n    c\n\n  d\ne", "a[b[c] d] e", ""},
+		{"a:\n\tb:\n\t\tc\n", "a[b[c]]", ""},
+		{"a:\n  b:\n    c\nd:\n e", "a[b[c]] d[e]", ""},
+		{"a:\nb", "", "2:1: expected indented block"},
+		{"a:\n  b\n c", "", `3:1: expected one of same indentation, word, newline, end of input`},
+		{"a:\n  b:\n\tc", "", "3:1: Mixed tabs and spaces in indentation"},
+		{"a:\n \tb", "", "2:1: Mixed tabs and spaces in indentation"},
+	}
+
+	for i, tt := range tests {
+		for _, memo := range []bool{false, true} {
+			s := NewScanner(tt.input)
+			if memo {
+				s.EnableMemo()
+			}
+
+			node, err := lProgram.Lex(s)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("TestIndentLexer[%d]: expected error=%q got=%v",
+						i, tt.err, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("TestIndentLexer[%d]: unexpected error=%s", i, err.Error())
+			}
+			if got := renderBlocks(node); got != tt.expected {
+				t.Fatalf("TestIndentLexer[%d]: expected=%s got=%s", i, tt.expected, got)
+			}
+			if s.indent != nil {
+				t.Fatalf("TestIndentLexer[%d]: block left open", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package lexer
+
+import (
+	"encoding/json"
+)
+
+// lexNodeJSON is how a LexNode is encoded as JSON.
+type lexNodeJSON struct {
+	Name      string     `json:"name"`
+	Value     string     `json:"value"`
+	GroupName string     `json:"group,omitempty"`
+	Start     Position   `json:"start"`
+	End       Position   `json:"end"`
+	Children  []*LexNode `json:"children,omitempty"`
+}
+
+// MarshalJSON encodes the node and its children, as
+//
+//	{"name": "atom", "value": "let", "group": "keyword",
+//	 "start": {"offset": 0, "line": 1, "column": 1}, "end": {...},
+//	 "children": [...]}
+//
+// where the group and children are left out when there are none. Extra
+// is not encoded, as it may hold anything.
+func (n *LexNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lexNodeJSON{
+		Name:      n.Name,
+		Value:     n.Value,
+		GroupName: n.GroupName,
+		Start:     n.Start,
+		End:       n.End,
+		Children:  n.Children,
+	})
+}
+
+// UnmarshalJSON decodes a node as encoded by MarshalJSON.
+func (n *LexNode) UnmarshalJSON(data []byte) error {
+	var node lexNodeJSON
+	if err := json.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	*n = LexNode{
+		Name:      node.Name,
+		Value:     node.Value,
+		GroupName: node.GroupName,
+		Children:  node.Children,
+		Start:     node.Start,
+		End:       node.End,
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package lexer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLexNodeJSON(t *testing.T) {
+	word := Group("word", Regex("[a-z]+", false))
+	quoted := Group("quoted", Regex(`"[^"]*"`, false))
+	l := Interlace(Or(word, quoted), Regex("[ \n]+", false))
+
+	tests := []struct {
+		input string
+		json  string
+	}{
+		{"ab", `{"name":"or","value":"ab","start":{"offset":0,"line":1,"column":1},` +
+			`"end":{"offset":2,"line":1,"column":3},"children":[` +
+			`{"name":"regex","value":"ab","group":"word","start":{"offset":0,"line":1,"column":1},` +
+			`"end":{"offset":2,"line":1,"column":3}}]}`},
+		{"ab \"c\\\"\"\nd", ""},
+	}
+
+	for i, tt := range tests {
+		node, lexErr := l.Lex(NewScanner(tt.input))
+		if lexErr != nil {
+			t.Fatalf("TestLexNodeJSON[%d]: unexpected error=%s", i, lexErr.Error())
+		}
+		if len(node.Children) == 1 {
+			node = node.Children[0]
+		}
+
+		data, err := json.Marshal(node)
+		if err != nil {
+			t.Fatalf("TestLexNodeJSON[%d]: unexpected error=%s", i, err.Error())
+		}
+		if tt.json != "" && string(data) != tt.json {
+			t.Fatalf("TestLexNodeJSON[%d]: expected=%s got=%s", i, tt.json, data)
+		}
+
+		// Decoding gives the same tree, as does decoding String
+		for _, encoded := range []string{string(data), node.String(2)} {
+			decoded := &LexNode{}
+			if err := json.Unmarshal([]byte(encoded), decoded); err != nil {
+				t.Fatalf("TestLexNodeJSON[%d]: unexpected error=%s in %s", i, err.Error(), encoded)
+			}
+			if !reflect.DeepEqual(decoded, withoutExtra(node)) {
+				t.Fatalf("TestLexNodeJSON[%d]: expected=%s got=%s", i, node.String(0), decoded.String(0))
+			}
+		}
+	}
+}
+
+// withoutExtra returns a copy of the tree with only the fields that are
+// encoded as JSON.
+func withoutExtra(n *LexNode) *LexNode {
+	node := &LexNode{
+		Name:      n.Name,
+		Value:     n.Value,
+		GroupName: n.GroupName,
+		Start:     n.Start,
+		End:       n.End,
+	}
+	for _, child := range n.Children {
+		node.Children = append(node.Children, withoutExtra(child))
+	}
+	return node
+}
@@ -1,7 +1,10 @@
 package lexer
 
 import (
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -11,13 +14,37 @@ type Lexer interface {
 }
 
 type LexNode struct {
-	Name       string
-	Value      string
-	GroupName  string
-	Children   []*LexNode
-	Extra      interface{}
-	LineNumber int
-	LineIndex  int
+	Name      string
+	Value     string
+	GroupName string
+	Children  []*LexNode
+	Extra     interface{}
+	Start     Position
+	End       Position
+
+	// Whether some of the input within this node was
+	// left out of its value, because of Ignore.
+	ignored bool
+}
+
+// value returns the value for a node made up of the given children,
+// spanning from start up to the current position. This is a slice of
+// the input rather than a copy, unless some of it has to be left out,
+// or was already released by a scanner reading from a reader.
+func (s *Scanner) value(start Position, children []*LexNode) (string, bool) {
+	ignored := false
+	for _, child := range children {
+		ignored = ignored || child.ignored
+	}
+	if !ignored && start.Offset >= s.base {
+		return s.slice(start.Offset, s.index), false
+	}
+
+	var value strings.Builder
+	for _, child := range children {
+		value.WriteString(child.Value)
+	}
+	return value.String(), ignored
 }
 
 func (n *LexNode) Groups(group string) ([]*LexNode, int) {
@@ -81,60 +108,134 @@ func (n *LexNode) GroupExists(group string) bool {
 	return n.GroupDepth(group) >= 0
 }
 
-func (n *LexNode) String(depth int) string {
-	result := strings.Repeat("  ", depth) + "{\n"
-	depth++
-
-	value := n.Value
-	value = strings.Replace(value, "\n", "\\n", -1)
-	value = strings.Replace(value, "\r", "\\r", -1)
-	value = strings.Replace(value, "\t", "\\t", -1)
-
-	result += strings.Repeat("  ", depth) + "\"name\": \"" + n.Name + "\",\n"
-	result += strings.Repeat("  ", depth) + "\"value\": \"" + value + "\",\n"
-	result += strings.Repeat("  ", depth) + "\"group\": \"" + n.GroupName + "\",\n"
-	result += strings.Repeat("  ", depth) + "\"children\": ["
-	if len(n.Children) == 0 {
-		result += "]\n"
-		depth--
-		result += strings.Repeat("  ", depth) + "}"
-		return result
-	}
-	result += "\n"
+// Errors returns the errors of all nodes in this tree that were
+// produced by Recover, in the order they occur in the input.
+func (n *LexNode) Errors() []*LexError {
+	var errors []*LexError
+	if lexErr, ok := n.Extra.(*LexError); ok && n.Name == "error" {
+		errors = append(errors, lexErr)
+	}
 	for _, child := range n.Children {
-		result += child.String(depth+1) + ",\n"
+		errors = append(errors, child.Errors()...)
 	}
-	result += strings.Repeat("  ", depth) + "]\n"
-	depth--
-	result += strings.Repeat("  ", depth) + "}"
-	return result
+	return errors
 }
 
+// Comments returns all comments in this tree, as matched by
+// BlockComment and LineComment, in the order they occur in the input.
+func (n *LexNode) Comments() []*LexNode {
+	var comments []*LexNode
+	if n.Name == "comment" {
+		comments = append(comments, n)
+	}
+	for _, child := range n.Children {
+		comments = append(comments, child.Comments()...)
+	}
+	return comments
+}
+
+// String formats the tree as indented JSON, see MarshalJSON, with
+// every line indented by depth levels.
+func (n *LexNode) String(depth int) string {
+	indent := strings.Repeat("  ", depth)
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent(indent, "  ")
+	encoder.Encode(n)
+	return indent + strings.TrimSuffix(b.String(), "\n")
+}
+
+// LexError describes why lexing failed. Rather than reporting the
+// failure of every alternative that was tried, it reports the
+// farthest position any lexer reached and what was expected there.
 type LexError struct {
 	err   string
-	stack []string
+	stack []traceFrame
+
+	position Position
+	expected []string
+
+	// Whether the error is about the input at its position, rather
+	// than about what was expected. Such errors keep their position
+	// and message, no matter how far other lexers got.
+	fixed bool
+
+	committed bool
+}
+
+// traceFrame records a lexer that an error was traced through.
+// It is only formatted once asked for, as that is expensive for
+// large grammars.
+type traceFrame struct {
+	lexer    Lexer
+	position Position
 }
 
 func err(s *Scanner, err string, lex Lexer) *LexError {
 	e := &LexError{
-		err:   err,
-		stack: []string{},
+		err: err,
 	}
 	e.Trace(s, lex)
 	return e
 }
 
 func (e *LexError) Error() string {
-	str := e.err
-	for _, err := range e.stack {
-		str += "\n" + err
+	switch len(e.expected) {
+	case 0:
+		return fmt.Sprintf("%s: %s", e.position, e.err)
+	case 1:
+		return fmt.Sprintf("%s: expected %s", e.position, e.expected[0])
 	}
-	return str
+	return fmt.Sprintf("%s: expected one of %s",
+		e.position, strings.Join(e.expected, ", "))
+}
+
+// Expected returns everything that was expected at the position
+// of the error.
+func (e *LexError) Expected() []string {
+	return e.expected
+}
+
+// Position returns where the error occurred.
+func (e *LexError) Position() Position {
+	return e.position
+}
+
+// Committed returns whether the error occurred after a Cut, in
+// which case no alternatives were tried after it.
+func (e *LexError) Committed() bool {
+	return e.committed
+}
+
+// Stack returns the lexers that were being applied when the
+// error occurred, innermost first.
+func (e *LexError) Stack() []string {
+	stack := make([]string, len(e.stack))
+	for i, frame := range e.stack {
+		stack[i] = fmt.Sprintf("at %s lexing %s",
+			frame.position, frame.lexer.ToString())
+	}
+	return stack
 }
 
 func (e *LexError) Trace(s *Scanner, lex Lexer) {
-	e.stack = append(e.stack, fmt.Sprintf("at %d:%d lexing %s",
-		s.lineNumber, s.lineIndex, lex.ToString()))
+	e.stack = append(e.stack, traceFrame{
+		lexer:    lex,
+		position: s.Position(),
+	})
+	if e.fixed {
+		return
+	}
+
+	// Point at the farthest failure known to the scanner. The
+	// scanner never modifies expectations it has handed out.
+	e.position = s.Position()
+	e.expected = nil
+	if s.failure.index >= 0 {
+		e.position = s.failure.position()
+		e.expected = s.expected
+	}
 }
 
 type AtomLexer struct {
@@ -146,15 +247,17 @@ func Atom(atom string) Lexer {
 }
 
 func (p *AtomLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
 	if s.ConsumeString(p.atom) != p.atom {
+		s.expect(strconv.Quote(p.atom))
 		return nil, err(s, fmt.Sprintf("Expected atom: %s", p.atom), p)
 	}
 
 	return &LexNode{
-		Name:       "atom",
-		Value:      p.atom,
-		LineNumber: s.lineNumber,
-		LineIndex:  s.lineIndex,
+		Name:  "atom",
+		Value: p.atom,
+		Start: start,
+		End:   s.Position(),
 	}, nil
 }
 
@@ -164,27 +267,34 @@ func (p *AtomLexer) ToString() string {
 
 type RegexLexer struct {
 	pattern    string
+	regexp     *regexp.Regexp
 	allowEmpty bool
 }
 
+// Regex matches the given pattern at the current position. The
+// pattern is compiled right away, so an invalid pattern panics
+// while the grammar is being built rather than while lexing.
 func Regex(pattern string, allowEmpty bool) Lexer {
 	return &RegexLexer{
 		pattern:    pattern,
+		regexp:     compileAnchored(pattern),
 		allowEmpty: allowEmpty,
 	}
 }
 
 func (p *RegexLexer) Lex(s *Scanner) (*LexNode, *LexError) {
-	str := s.ConsumeRegex(p.pattern)
+	start := s.Position()
+	str := s.ConsumeRegexp(p.regexp)
 	if !p.allowEmpty && str == "" {
+		s.expect("/" + p.pattern + "/")
 		return nil, err(s, fmt.Sprintf("Expected regex: %s", p.pattern), p)
 	}
 
 	return &LexNode{
-		Name:       "regex",
-		Value:      str,
-		LineNumber: s.lineNumber,
-		LineIndex:  s.lineIndex,
+		Name:  "regex",
+		Value: str,
+		Start: start,
+		End:   s.Position(),
 	}, nil
 }
 
@@ -203,8 +313,8 @@ func And(children ...Lexer) Lexer {
 }
 
 func (p *AndLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
 	var nodes []*LexNode
-	var value string
 
 	for _, child := range p.children {
 		node, err := child.Lex(s)
@@ -213,15 +323,17 @@ func (p *AndLexer) Lex(s *Scanner) (*LexNode, *LexError) {
 			return nil, err
 		}
 		nodes = append(nodes, node)
-		value += node.Value
 	}
 
+	value, ignored := s.value(start, nodes)
+
 	return &LexNode{
-		Name:       "and",
-		Children:   nodes,
-		Value:      value,
-		LineNumber: s.lineNumber,
-		LineIndex:  s.lineIndex,
+		Name:     "and",
+		Children: nodes,
+		Value:    value,
+		ignored:  ignored,
+		Start:    start,
+		End:      s.Position(),
 	}, nil
 }
 
@@ -248,26 +360,32 @@ func Or(children ...Lexer) Lexer {
 }
 
 func (p *OrLexer) Lex(s *Scanner) (*LexNode, *LexError) {
-	var errors []string
-	for _, child := range p.children {
+	start := s.Position()
+	for i, child := range p.children {
 		s.Push()
 		node, err := child.Lex(s)
 		if err == nil {
 			s.Discard()
+			s.coverAlternative(p, i)
 			return &LexNode{
-				Name:       "or",
-				Value:      node.Value,
-				Children:   []*LexNode{node},
-				LineNumber: s.lineNumber,
-				LineIndex:  s.lineIndex,
+				Name:     "or",
+				Value:    node.Value,
+				ignored:  node.ignored,
+				Children: []*LexNode{node},
+				Start:    start,
+				End:      s.Position(),
 			}, nil
 		}
-		errors = append(errors, "\t"+err.Error())
+		if err.committed || s.Committed() {
+			s.Pop()
+			err.committed = true
+			err.Trace(s, p)
+			return nil, err
+		}
 		s.Pop()
 	}
 
-	return nil, err(s, fmt.Sprintf(
-		"Failed to lex OR:\n%s", strings.Join(errors, "\n")), p)
+	return nil, err(s, "Failed to lex OR", p)
 }
 
 func (p *OrLexer) ToString() string {
@@ -305,9 +423,9 @@ func Optional(child Lexer) Lexer {
 }
 
 func (p *RepeatLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
 	var count int
 	var nodes []*LexNode
-	var value string
 
 	// Loop while we haven't reached the maximum yet
 	for p.max < 0 || count <= p.max {
@@ -315,9 +433,12 @@ func (p *RepeatLexer) Lex(s *Scanner) (*LexNode, *LexError) {
 		s.Push()
 		node, err := p.child.Lex(s)
 		if err != nil {
-			// If we haven't reached minimum yet, return error
-			if p.min >= 0 && count < p.min {
+			// If we haven't reached minimum yet, or we may
+			// not backtrack because of a cut, return error
+			committed := err.committed || s.Committed()
+			if committed || (p.min >= 0 && count < p.min) {
 				s.Discard()
+				err.committed = committed
 				err.Trace(s, p)
 				return nil, err
 			}
@@ -326,18 +447,20 @@ func (p *RepeatLexer) Lex(s *Scanner) (*LexNode, *LexError) {
 			s.Pop()
 			break
 		}
+		s.Discard()
 		nodes = append(nodes, node)
-		value += node.Value
 		count++
 	}
 
 	// Return the result
+	value, ignored := s.value(start, nodes)
 	return &LexNode{
-		Name:       "repeat",
-		Children:   nodes,
-		Value:      value,
-		LineNumber: s.lineNumber,
-		LineIndex:  s.lineIndex,
+		Name:     "repeat",
+		Children: nodes,
+		Value:    value,
+		ignored:  ignored,
+		Start:    start,
+		End:      s.Position(),
 	}, nil
 }
 
@@ -359,16 +482,44 @@ func Group(name string, child Lexer) Lexer {
 }
 
 func (p *GroupLexer) Lex(s *Scanner) (*LexNode, *LexError) {
-	node, err := p.child.Lex(s)
-	if node != nil {
-		node.GroupName = p.groupName
-	}
-	if err != nil {
-		err.Trace(s, p)
-	}
+	node, err := s.memoize(p, func() (*LexNode, *LexError) {
+		start := s.index
+		mark := s.expectMark(start)
+
+		node, err := p.child.Lex(s)
+		if node != nil {
+			node.GroupName = p.groupName
+		}
+		if err != nil {
+			// A group around a single token names that token
+			// in syntax errors, e.g. "identifier".
+			if p.terminal() {
+				s.relabel(start, mark, p.groupName)
+			}
+			err.Trace(s, p)
+		}
+		return node, err
+	})
+	s.cover(p, err == nil)
 	return node, err
 }
 
+func (p *GroupLexer) terminal() bool {
+	return terminal(p.child)
+}
+
+// terminal returns whether lex matches a single token, rather than
+// a structure made up of other tokens.
+func terminal(lex Lexer) bool {
+	switch lex := lex.(type) {
+	case *AtomLexer, *RegexLexer, *RuneLexer, *RuneClassLexer, *KeywordLexer:
+		return true
+	case *ReservedLexer:
+		return terminal(lex.child)
+	}
+	return false
+}
+
 func (p *GroupLexer) ToString() string {
 	return fmt.Sprintf("GROUP(\"%s\", %s)",
 		p.groupName, p.child.ToString())
@@ -387,9 +538,12 @@ func Ignore(child Lexer) Lexer {
 func (p *IgnoreLexer) Lex(s *Scanner) (*LexNode, *LexError) {
 	node, err := p.child.Lex(s)
 	if node != nil {
+		node.ignored = node.ignored || node.Value != ""
 		node.Value = ""
 	}
-	err.Trace(s, p)
+	if err != nil {
+		err.Trace(s, p)
+	}
 	return node, err
 }
 
@@ -410,7 +564,11 @@ func Future(pointer *Lexer, name string) *FutureLexer {
 }
 
 func (p *FutureLexer) Lex(s *Scanner) (*LexNode, *LexError) {
-	return (*p.pointer).Lex(s)
+	node, err := s.memoize(p, func() (*LexNode, *LexError) {
+		return s.growSeed(p, *p.pointer)
+	})
+	s.cover(p, err == nil)
+	return node, err
 }
 
 func (p *FutureLexer) ToString() string {
@@ -430,11 +588,11 @@ func Interlace(outer Lexer, inner Lexer) Lexer {
 }
 
 func (p *InterlaceLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
 
 	var nodes []*LexNode
 	var outer *LexNode
 	var inner *LexNode
-	var value string
 	var err *LexError
 
 	for {
@@ -448,28 +606,33 @@ func (p *InterlaceLexer) Lex(s *Scanner) (*LexNode, *LexError) {
 		}
 
 		if err != nil {
+			committed := err.committed || s.Committed()
 
 			// We failed just now, so pop at least once.
 			// Since the other half of the pair (if any)
 			// isn't used, we need to pop for those as well!
 			s.Pop()
 			if outer != nil || inner != nil {
+				committed = committed || s.Committed()
 				s.Pop()
 			}
 
 			// If we already parsed at least one 'outer',
-			// then we return success.
-			if len(nodes) > 0 {
+			// then we return success, unless a cut forbids it.
+			if len(nodes) > 0 && !committed {
+				value, ignored := s.value(start, nodes)
 				return &LexNode{
-					Name:       "interlace",
-					Value:      value,
-					Children:   nodes,
-					LineNumber: s.lineNumber,
-					LineIndex:  s.lineIndex,
+					Name:     "interlace",
+					Value:    value,
+					ignored:  ignored,
+					Children: nodes,
+					Start:    start,
+					End:      s.Position(),
 				}, nil
 			}
 
 			// Otherwise, we return the error.
+			err.committed = committed
 			err.Trace(s, p)
 			return nil, err
 		}
@@ -480,8 +643,6 @@ func (p *InterlaceLexer) Lex(s *Scanner) (*LexNode, *LexError) {
 			outer = nil
 			s.Discard()
 		} else if outer != nil && inner != nil {
-			value += inner.Value
-			value += outer.Value
 			nodes = append(nodes, inner)
 			nodes = append(nodes, outer)
 			inner = nil
@@ -496,3 +657,126 @@ func (p *InterlaceLexer) ToString() string {
 	return fmt.Sprintf("INTERLACE(%s, %s)",
 		p.outer.ToString(), p.inner.ToString())
 }
+
+type CutLexer struct{}
+
+// Cut commits to the alternative it appears in. Once a cut was
+// lexed, the innermost enclosing Or, Repeat or Interlace will not
+// backtrack to try anything else; a later failure is reported as
+// is, and no longer backtracked over by any enclosing lexer.
+func Cut() Lexer {
+	return &CutLexer{}
+}
+
+func (p *CutLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	s.Commit()
+	return &LexNode{
+		Name:  "cut",
+		Start: start,
+		End:   s.Position(),
+	}, nil
+}
+
+func (p *CutLexer) ToString() string {
+	return "CUT()"
+}
+
+type RecoverLexer struct {
+	child Lexer
+	sync  Lexer
+}
+
+// Recover lexes child, but instead of failing it skips ahead to the
+// next position where sync matches. The skipped input is returned
+// as an "error" node, with the original *LexError in Extra. Nothing
+// is consumed by sync itself, so it may match a closing brace that
+// is still needed by an enclosing lexer.
+func Recover(child Lexer, sync Lexer) Lexer {
+	return &RecoverLexer{
+		child: child,
+		sync:  sync,
+	}
+}
+
+func (p *RecoverLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	s.Push()
+	node, lexErr := p.child.Lex(s)
+	if lexErr == nil {
+		s.Discard()
+		return node, nil
+	}
+	s.Pop()
+
+	// Skip ahead from wherever the child got stuck, which depends
+	// on how far anything failed before
+	switch {
+	case lexErr.fixed:
+	case lexErr.expected == nil:
+		s.readFailure(-1)
+	default:
+		s.readFailure(lexErr.position.Offset)
+	}
+	start := s.Position()
+	var skipped strings.Builder
+	if lexErr.position.Offset > s.index {
+		skipped.WriteString(s.Forward(lexErr.position.Offset - s.index))
+	}
+	for !s.atEnd() {
+		s.Push()
+		_, syncErr := p.sync.Lex(s)
+		s.Pop()
+		if syncErr == nil {
+			break
+		}
+		skipped.WriteString(s.Forward(1))
+	}
+
+	// If there was nothing to skip, there is nothing to recover
+	if s.index == start.Offset {
+		lexErr.Trace(s, p)
+		return nil, lexErr
+	}
+
+	// Failures up to here have been reported through this node,
+	// and shouldn't be reported again by whatever comes next.
+	s.forgetFailures()
+
+	return &LexNode{
+		Name:  "error",
+		Value: skipped.String(),
+		Extra: lexErr,
+		Start: start,
+		End:   s.Position(),
+	}, nil
+}
+
+func (p *RecoverLexer) ToString() string {
+	return fmt.Sprintf("RECOVER(%s, %s)",
+		p.child.ToString(), p.sync.ToString())
+}
+
+type EOFLexer struct{}
+
+// EOF only matches at the end of the input.
+func EOF() Lexer {
+	return &EOFLexer{}
+}
+
+func (p *EOFLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	if !s.atEnd() {
+		s.expect("end of input")
+		return nil, err(s, "Expected end of input", p)
+	}
+
+	return &LexNode{
+		Name:  "eof",
+		Start: start,
+		End:   s.Position(),
+	}, nil
+}
+
+func (p *EOFLexer) ToString() string {
+	return "EOF()"
+}
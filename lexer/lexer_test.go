@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -69,6 +70,31 @@ func TestRegexLexer(t *testing.T) {
 	}
 }
 
+func TestRegexInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("TestRegexInvalid: expected panic")
+		}
+	}()
+	Regex("[a-z", false)
+}
+
+func BenchmarkRegexLexer(b *testing.B) {
+	input := strings.Repeat("identifier \n\t", 512)
+	lex := Repeat(And(
+		Regex("[a-z]+", false),
+		Regex("[\n\t\r ]*", true),
+	), 0, -1)
+
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		s := NewScanner(input)
+		if _, err := lex.Lex(s); err != nil {
+			b.Fatalf("BenchmarkRegexLexer: %s", err.Error())
+		}
+	}
+}
+
 func TestAndLexer(t *testing.T) {
 	tests := []struct {
 		input     string
@@ -185,6 +211,19 @@ func TestRepeatLexer(t *testing.T) {
 	}
 }
 
+func TestRepeatBacktrack(t *testing.T) {
+	lex := Or(And(Repeat(Atom("a"), 0, -1), Atom("b")), Atom("aac"))
+
+	s := NewScanner("aac")
+	node, err := lex.Lex(s)
+	if err != nil {
+		t.Fatalf("TestRepeatBacktrack: unexpected error=%s", err.Error())
+	}
+	if node.Value != "aac" {
+		t.Fatalf("TestRepeatBacktrack: expected=aac got=%s", node.Value)
+	}
+}
+
 func TestInterlaceLexer(t *testing.T) {
 	tests := []struct {
 		input     string
@@ -250,3 +289,206 @@ func TestToString(t *testing.T) {
 		}
 	}
 }
+
+func TestLexError(t *testing.T) {
+	tests := []struct {
+		input    string
+		lex      Lexer
+		expected string
+	}{
+		{"c", Or(Atom("a"), Atom("b")), `1:1: expected one of "a", "b"`},
+		{"ac", Or(And(Atom("a"), Atom("b")), Atom("c")), `1:2: expected "b"`},
+		{"a\nac", And(Atom("a\na"), Or(Atom("b"), Group("name", Regex("[0-9]", false)))),
+			`2:2: expected one of "b", name`},
+		{"1", Regex("[a-z]+", false), "1:1: expected /[a-z]+/"},
+		{"ab", And(Atom("a"), EOF()), "1:2: expected end of input"},
+		{"aab", And(Repeat(Atom("a"), 0, -1), Or(Atom("c"), EOF())),
+			`1:3: expected one of "a", "c", end of input`},
+	}
+
+	for i, tt := range tests {
+		s := NewScanner(tt.input)
+		_, err := tt.lex.Lex(s)
+		if err == nil {
+			t.Fatalf("TestLexError[%d]: expected error", i)
+		}
+		if err.Error() != tt.expected {
+			t.Fatalf("TestLexError[%d]: expected=%q got=%q",
+				i, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestCutLexer(t *testing.T) {
+	tests := []struct {
+		input     string
+		lex       Lexer
+		expected  string
+		committed bool
+	}{
+		{"ac", Or(And(Atom("a"), Atom("b")), Atom("ac")), "ac", false},
+		{"ac", Or(And(Atom("a"), Cut(), Atom("b")), Atom("ac")), "", true},
+		{"ac", Or(And(Atom("x"), Cut(), Atom("b")), Atom("ac")), "ac", false},
+		{"ac", Or(Or(And(Atom("a"), Cut(), Atom("b"))), Atom("ac")), "", true},
+		{"ac", Or(Group("g", And(Atom("a"), Cut())), Atom("ac")), "a", false},
+		{"abab", Repeat(And(Atom("a"), Cut(), Atom("b")), 0, -1), "abab", false},
+		{"abac", Repeat(And(Atom("a"), Cut(), Atom("b")), 0, -1), "", true},
+		{"abc", Repeat(And(Atom("a"), Cut(), Atom("b")), 0, -1), "ab", false},
+		{"a,a,", Interlace(Atom("a"), And(Atom(","), Cut())), "", true},
+		{"a,a;", Interlace(Atom("a"), Atom(",")), "a,a", false},
+	}
+
+	for i, tt := range tests {
+		for _, memo := range []bool{false, true} {
+			s := NewScanner(tt.input)
+			if memo {
+				s.EnableMemo()
+			}
+
+			node, err := tt.lex.Lex(s)
+			if tt.committed {
+				if err == nil || !err.Committed() {
+					t.Fatalf("TestCutLexer[%d]: expected committed error", i)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("TestCutLexer[%d]: unexpected error=%s",
+					i, err.Error())
+			}
+			if node.Value != tt.expected {
+				t.Fatalf("TestCutLexer[%d]: expected=%s got=%s",
+					i, tt.expected, node.Value)
+			}
+		}
+	}
+}
+
+func TestRecoverLexer(t *testing.T) {
+	tests := []struct {
+		input     string
+		lex       Lexer
+		value     string
+		remainder string
+		errors    int
+		fail      bool
+	}{
+		{"ab;", Recover(Atom("ab"), Atom(";")), "ab", ";", 0, false},
+		{"ac;b", Recover(Atom("ab"), Atom(";")), "ac", ";b", 1, false},
+		{"ac", Recover(Atom("ab"), Atom(";")), "ac", "", 1, false},
+		{";b", Recover(Atom("ab"), Atom(";")), "", ";b", 0, true},
+		{"abcd;", Recover(And(Atom("ab"), Cut(), Atom("x")), Atom(";")), "abcd", ";", 1, false},
+		{"ab;xx;ab", Interlace(Recover(Atom("ab"), Atom(";")), Atom(";")), "ab;xx;ab", "", 1, false},
+	}
+
+	for i, tt := range tests {
+		s := NewScanner(tt.input)
+		node, err := tt.lex.Lex(s)
+		if tt.fail {
+			if err == nil {
+				t.Fatalf("TestRecoverLexer[%d]: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestRecoverLexer[%d]: unexpected error=%s",
+				i, err.Error())
+		}
+		if node.Value != tt.value {
+			t.Fatalf("TestRecoverLexer[%d]: expected=%s got=%s",
+				i, tt.value, node.Value)
+		}
+		if s.Remainder() != tt.remainder {
+			t.Fatalf("TestRecoverLexer[%d]: remainder expected=%s got=%s",
+				i, tt.remainder, s.Remainder())
+		}
+		if len(node.Errors()) != tt.errors {
+			t.Fatalf("TestRecoverLexer[%d]: errors expected=%d got=%d",
+				i, tt.errors, len(node.Errors()))
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	lex := And(
+		Atom("let"),
+		Regex("[\n ]+", false),
+		Or(Atom("x"), Atom("yz")),
+	)
+	tests := []struct {
+		node  func(*LexNode) *LexNode
+		start Position
+		end   Position
+	}{
+		{func(n *LexNode) *LexNode { return n }, Position{0, 1, 1}, Position{8, 2, 4}},
+		{func(n *LexNode) *LexNode { return n.Children[0] }, Position{0, 1, 1}, Position{3, 1, 4}},
+		{func(n *LexNode) *LexNode { return n.Children[1] }, Position{3, 1, 4}, Position{6, 2, 2}},
+		{func(n *LexNode) *LexNode { return n.Children[2] }, Position{6, 2, 2}, Position{8, 2, 4}},
+	}
+
+	s := NewScanner("let \n yz")
+	root, err := lex.Lex(s)
+	if err != nil {
+		t.Fatalf("TestSpans: unexpected error=%s", err.Error())
+	}
+
+	for i, tt := range tests {
+		node := tt.node(root)
+		if node.Start != tt.start {
+			t.Fatalf("TestSpans[%d]: start expected=%v got=%v",
+				i, tt.start, node.Start)
+		}
+		if node.End != tt.end {
+			t.Fatalf("TestSpans[%d]: end expected=%v got=%v",
+				i, tt.end, node.End)
+		}
+	}
+}
+
+func TestIgnoreLexer(t *testing.T) {
+	tests := []struct {
+		input    string
+		lex      Lexer
+		expected string
+	}{
+		{"a b", And(Atom("a"), Ignore(Atom(" ")), Atom("b")), "ab"},
+		{"a b c", Interlace(Regex("[a-z]", false), Ignore(Atom(" "))), "abc"},
+		{"a b", Or(And(Atom("a"), Ignore(Atom(" ")), Atom("b"))), "ab"},
+		{"a b", Repeat(Or(Regex("[a-z]", false), Ignore(Atom(" "))), 0, -1), "ab"},
+		{"ab", And(Atom("a"), Ignore(Regex("x*", true)), Atom("b")), "ab"},
+	}
+
+	for i, tt := range tests {
+		s := NewScanner(tt.input)
+		node, err := tt.lex.Lex(s)
+		if err != nil {
+			t.Fatalf("TestIgnoreLexer[%d]: unexpected error=%s",
+				i, err.Error())
+		}
+		if node.Value != tt.expected {
+			t.Fatalf("TestIgnoreLexer[%d]: expected=%q got=%q",
+				i, tt.expected, node.Value)
+		}
+	}
+}
+
+func BenchmarkAndLexer(b *testing.B) {
+	input := strings.Repeat("let x = 5\n", 1000)
+	lex := Repeat(And(
+		Atom("let"),
+		Regex(" +", false),
+		Regex("[a-z]+", false),
+		Atom(" = "),
+		Regex("[0-9]+", false),
+		Atom("\n"),
+	), 0, -1)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		s := NewScanner(input)
+		if _, err := lex.Lex(s); err != nil {
+			b.Fatalf("BenchmarkAndLexer: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package lexer
+
+// memoKey identifies the result of applying a lexer at
+// a specific index of the input, within the given blocks.
+type memoKey struct {
+	lexer  Lexer
+	index  int
+	indent *indentLevel
+}
+
+// memoEntry stores the outcome of a lexer application,
+// together with the scanner position it ended at.
+type memoEntry struct {
+	node  *LexNode
+	err   *LexError
+	state scannerState
+	cut   bool
+
+	// The failures of the application itself, which was made as if
+	// nothing failed before it, see memoize.
+	failures failures
+	frame    memoFrame
+}
+
+// memoFrame tracks how the outcome of the memoized lexer that is being
+// applied depends on the failures before it. Relabeling depends on
+// what failed at the index being relabeled, and Recover skips up to the
+// farthest failure, so the outcome may depend on the failures before,
+// unless they came before the failure that was read, or were forgotten
+// in the meantime.
+type memoFrame struct {
+	read  bool // whether a failure was read
+	floor int  // the lowest index of a failure that was read
+	reset bool // whether the failures before were forgotten
+}
+
+// readFailure records that the farthest failure, which was at index
+// or -1 for none, decided how lexing goes on.
+func (s *Scanner) readFailure(index int) {
+	if s.frame.reset {
+		return
+	}
+	if !s.frame.read || index < s.frame.floor {
+		s.frame.read = true
+		s.frame.floor = index
+	}
+}
+
+// dependsOn returns whether an application that read failures as f
+// did would have gone otherwise after the given failure.
+func (f memoFrame) dependsOn(failure scannerState) bool {
+	return f.read && failure.index >= 0 && failure.index >= f.floor
+}
+
+// EnableMemo turns on packrat memoization for this scanner.
+// Once enabled, the results of Future and Group lexers are
+// stored per input index, so that alternatives that are
+// retried after backtracking don't lex the same input twice.
+func (s *Scanner) EnableMemo() {
+	if s.memo == nil {
+		s.memo = make(map[memoKey]*memoEntry)
+	}
+}
+
+// memoize applies fn, or replays its stored result if the
+// same lexer was already applied at the current index.
+//
+// The result may not depend on the failures before, as those differ
+// from one application to the next, so fn is applied as if nothing
+// failed before it, and its own failures are added to those before
+// afterwards. Should fn have read failures before, see readFailure,
+// it is applied once more with them.
+func (s *Scanner) memoize(lex Lexer, fn func() (*LexNode, *LexError)) (*LexNode, *LexError) {
+	if s.memo == nil {
+		return fn()
+	}
+
+	key := memoKey{lexer: lex, index: s.index, indent: s.indent}
+	before := s.saveFailures()
+	if entry, ok := s.memo[key]; ok {
+		if entry.frame.dependsOn(before.failure) {
+			return fn()
+		}
+		s.restore(entry.state)
+		if entry.cut {
+			s.Commit()
+		}
+		return s.replay(before, entry.frame, entry.failures, entry.node.copy(), entry.err.copy())
+	}
+
+	// The input may have to be lexed again from here
+	if before.failure.index >= 0 {
+		s.pinned++
+		defer func() { s.pinned-- }()
+	}
+
+	start := s.state()
+	parent := s.frame
+	s.failure, s.expected, s.frame = scannerState{index: -1}, nil, memoFrame{}
+
+	// Results that were built on top of a left recursion seed
+	// are only valid while that seed is being grown.
+	uses := s.seedUses
+	committed := s.Committed()
+	node, err := fn()
+	frame, failures := s.frame, s.saveFailures()
+	s.frame = parent
+
+	if s.seedUses == uses {
+		s.memo[key] = &memoEntry{
+			node:     node,
+			err:      err,
+			state:    s.state(),
+			cut:      !committed && s.Committed(),
+			failures: failures,
+			frame:    frame,
+		}
+
+		// The caller may rename or clear the result (see Group
+		// and Ignore), so never hand out the stored instance.
+		node, err = node.copy(), err.copy()
+	}
+
+	if frame.dependsOn(before.failure) {
+		s.restore(start)
+		if s.stack != nil {
+			s.stack.committed = committed
+		}
+		s.restoreFailures(before)
+		coverage := s.coverage
+		s.coverage = nil
+		defer func() { s.coverage = coverage }()
+		return fn()
+	}
+	return s.replay(before, frame, failures, node, err)
+}
+
+// replay adds the failures of a memoized application, as recorded in
+// frame and failures, to the failures before it. Its error is then
+// about the farthest of these, just as it would have been if the
+// application saw the failures before.
+func (s *Scanner) replay(before failures, frame memoFrame, failures failures, node *LexNode, err *LexError) (*LexNode, *LexError) {
+	if frame.read {
+		floor := frame.floor
+		if before.failure.index > floor {
+			floor = before.failure.index
+		}
+		s.readFailure(floor)
+	}
+	if frame.reset {
+		s.restoreFailures(failures)
+	} else {
+		s.restoreFailures(before)
+		s.mergeFailures(failures)
+	}
+	s.frame.reset = before.reset || frame.reset
+
+	if err != nil && !err.fixed && s.failure.index >= 0 {
+		err.position = s.failure.position()
+		err.expected = s.expected
+	}
+	return node, err
+}
+
+func (n *LexNode) copy() *LexNode {
+	if n == nil {
+		return nil
+	}
+	c := *n
+	return &c
+}
+
+func (e *LexError) copy() *LexError {
+	if e == nil {
+		return nil
+	}
+	c := *e
+	c.stack = e.stack[:len(e.stack):len(e.stack)]
+	return &c
+}
+
+// recursionHead tracks a Future that is currently being applied
+// at some index. If the Future is reached again at that same
+// index, it is left recursive, and the stored seed is returned
+// instead of recursing forever.
+type recursionHead struct {
+	node     *LexNode
+	state    scannerState
+	detected bool
+	uses     int
+}
+
+// growSeed applies lex on behalf of the given Future, using the
+// seed growing approach by Warth et al. to support left recursion.
+// The first application starts from a failing seed. If recursion
+// was detected, the rule is applied again and again, each time
+// using the previous result as seed, until it stops consuming
+// more input.
+func (s *Scanner) growSeed(future *FutureLexer, lex Lexer) (*LexNode, *LexError) {
+	if s.heads == nil {
+		s.heads = make(map[memoKey]*recursionHead)
+	}
+
+	// Reached the same rule at the same index: left recursion
+	key := memoKey{lexer: future, index: s.index, indent: s.indent}
+	if head, ok := s.heads[key]; ok {
+		// The seed may be grown from its start again, so the input
+		// from there on may not be released in the meantime. Nothing
+		// was consumed since the start, so nothing was released yet.
+		if !head.detected {
+			s.pinned++
+		}
+		head.detected = true
+		head.uses++
+		s.seedUses++
+		if head.node == nil {
+			return nil, err(s, "Left recursion without seed", future)
+		}
+		s.restore(head.state)
+		return head.node.copy(), nil
+	}
+
+	start := s.state()
+	head := &recursionHead{state: start}
+	s.heads[key] = head
+	defer delete(s.heads, key)
+
+	node, lexErr := lex.Lex(s)
+	if head.detected {
+		defer func() { s.pinned-- }()
+	}
+	if !head.detected || lexErr != nil {
+		s.seedUses -= head.uses
+		return node, lexErr
+	}
+
+	// Keep growing the seed while it consumes more input
+	for {
+		head.node = node
+		head.state = s.state()
+
+		s.restore(start)
+		node, lexErr = lex.Lex(s)
+		if lexErr != nil && lexErr.committed {
+			s.seedUses -= head.uses
+			return nil, lexErr
+		}
+		if lexErr != nil || s.index <= head.state.index {
+			break
+		}
+	}
+
+	s.restore(head.state)
+	s.seedUses -= head.uses
+	return head.node, nil
+}
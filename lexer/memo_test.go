@@ -0,0 +1,200 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+)
+
+type countingLexer struct {
+	child Lexer
+	count int
+}
+
+func (p *countingLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	p.count++
+	return p.child.Lex(s)
+}
+
+func (p *countingLexer) ToString() string {
+	return p.child.ToString()
+}
+
+func TestMemo(t *testing.T) {
+	tests := []struct {
+		input    string
+		memo     bool
+		count    int
+		expected string
+	}{
+		{"ab", false, 2, "ab"},
+		{"ab", true, 1, "ab"},
+		{"ac", false, 3, "ac"},
+		{"ac", true, 1, "ac"},
+	}
+
+	for i, tt := range tests {
+		counter := &countingLexer{child: Atom("a")}
+		var shared Lexer = Group("shared", counter)
+		lex := Or(
+			And(shared, Atom("x")),
+			And(shared, Atom("b")),
+			And(Group("renamed", shared), Atom("c")),
+		)
+
+		s := NewScanner(tt.input)
+		if tt.memo {
+			s.EnableMemo()
+		}
+
+		node, err := lex.Lex(s)
+		if err != nil {
+			t.Fatalf("TestMemo[%d]: unexpected error=%s", i, err.Error())
+		}
+		if node.Value != tt.expected {
+			t.Fatalf("TestMemo[%d]: expected=%s got=%s",
+				i, tt.expected, node.Value)
+		}
+		if counter.count != tt.count {
+			t.Fatalf("TestMemo[%d]: count expected=%d got=%d",
+				i, tt.count, counter.count)
+		}
+		if s.Remaining() != 0 {
+			t.Fatalf("TestMemo[%d]: remainder=%s", i, s.Remainder())
+		}
+	}
+}
+
+func TestMemoKeepsGroupName(t *testing.T) {
+	shared := Group("inner", Atom("a"))
+	lex := And(Group("outer", shared), shared)
+
+	s := NewScanner("aa")
+	s.EnableMemo()
+	node, err := lex.Lex(s)
+	if err != nil {
+		t.Fatalf("TestMemoKeepsGroupName: unexpected error=%s", err.Error())
+	}
+	if node.Children[0].GroupName != "outer" {
+		t.Fatalf("TestMemoKeepsGroupName: first expected=outer got=%s",
+			node.Children[0].GroupName)
+	}
+	if node.Children[1].GroupName != "inner" {
+		t.Fatalf("TestMemoKeepsGroupName: second expected=inner got=%s",
+			node.Children[1].GroupName)
+	}
+}
+
+func TestMemoFailures(t *testing.T) {
+	shared := Group("shared", And(Atom("a"), Repeat(Atom("b"), 0, -1)))
+	lex := Or(
+		And(shared, Atom("!")),
+		And(Recover(Atom("q"), Atom(";")), Atom("never")),
+		And(shared, Atom("?")),
+	)
+
+	var expected string
+	for _, memo := range []bool{false, true} {
+		s := NewScanner("a;")
+		if memo {
+			s.EnableMemo()
+		}
+		_, err := lex.Lex(s)
+		if err == nil {
+			t.Fatalf("TestMemoFailures[memo=%t]: expected error", memo)
+		}
+		if !memo {
+			expected = err.Error()
+			continue
+		}
+		if err.Error() != expected {
+			t.Fatalf("TestMemoFailures: expected=%s got=%s",
+				expected, err.Error())
+		}
+	}
+	if !strings.Contains(expected, `"b"`) {
+		t.Fatalf("TestMemoFailures: %q does not expect \"b\"", expected)
+	}
+}
+
+// renderSum renders a tree produced by a grammar of the form
+// `sum := sum op num | num` with explicit parentheses.
+func renderSum(n *LexNode) string {
+	switch n.Name {
+	case "or":
+		return renderSum(n.Children[0])
+	case "and":
+		return "(" + renderSum(n.Children[0]) + n.Children[1].Value +
+			renderSum(n.Children[2]) + ")"
+	}
+	return n.Value
+}
+
+func TestLeftRecursion(t *testing.T) {
+	tests := []struct {
+		input     string
+		memo      bool
+		expected  string
+		remainder string
+	}{
+		{"1", false, "1", ""},
+		{"1+2", false, "(1+2)", ""},
+		{"1+2+3", false, "((1+2)+3)", ""},
+		{"1+2-3+4", true, "(((1+2)-3)+4)", ""},
+		{"1+2+", true, "(1+2)", "+"},
+	}
+
+	for i, tt := range tests {
+		var sum Lexer
+		lSum := Future(&sum, "sum")
+		sum = Or(
+			And(lSum, Or(Atom("+"), Atom("-")), Regex("[0-9]", false)),
+			Regex("[0-9]", false),
+		)
+
+		s := NewScanner(tt.input)
+		if tt.memo {
+			s.EnableMemo()
+		}
+
+		node, err := lSum.Lex(s)
+		if err != nil {
+			t.Fatalf("TestLeftRecursion[%d]: unexpected error=%s",
+				i, err.Error())
+		}
+		if out := renderSum(node); out != tt.expected {
+			t.Fatalf("TestLeftRecursion[%d]: expected=%s got=%s",
+				i, tt.expected, out)
+		}
+		if s.Remainder() != tt.remainder {
+			t.Fatalf("TestLeftRecursion[%d]: remainder expected=%s got=%s",
+				i, tt.remainder, s.Remainder())
+		}
+	}
+}
+
+func TestIndirectLeftRecursion(t *testing.T) {
+	// a := b "x" | "y"
+	// b := a "z"
+	var a, b Lexer
+	lA := Future(&a, "a")
+	lB := Future(&b, "b")
+	a = Or(And(lB, Atom("x")), Atom("y"))
+	b = And(lA, Atom("z"))
+
+	for _, memo := range []bool{false, true} {
+		s := NewScanner("yzxzx")
+		if memo {
+			s.EnableMemo()
+		}
+
+		node, err := lA.Lex(s)
+		if err != nil {
+			t.Fatalf("TestIndirectLeftRecursion[%v]: unexpected error=%s",
+				memo, err.Error())
+		}
+		if node.Value != "yzxzx" {
+			t.Fatalf("TestIndirectLeftRecursion[%v]: expected=yzxzx got=%s",
+				memo, node.Value)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fixity describes where an operator goes relative to its operands.
+type Fixity int
+
+const (
+	Infix Fixity = iota
+	Prefix
+	Postfix
+)
+
+// Associativity describes how a chain of infix operators of the
+// same precedence is grouped: a - b - c is (a - b) - c, as - is
+// left associative.
+type Associativity int
+
+const (
+	LeftAssoc Associativity = iota
+	RightAssoc
+	NonAssoc
+)
+
+// Operator is a row in the table of operators given to Operators.
+// Operators with a higher precedence bind tighter.
+type Operator struct {
+	Fixity        Fixity
+	Associativity Associativity
+	Precedence    int
+	Token         Lexer
+}
+
+func InfixOp(precedence int, assoc Associativity, token Lexer) Operator {
+	return Operator{
+		Fixity:        Infix,
+		Associativity: assoc,
+		Precedence:    precedence,
+		Token:         token,
+	}
+}
+
+func PrefixOp(precedence int, token Lexer) Operator {
+	return Operator{
+		Fixity:     Prefix,
+		Precedence: precedence,
+		Token:      token,
+	}
+}
+
+func PostfixOp(precedence int, token Lexer) Operator {
+	return Operator{
+		Fixity:     Postfix,
+		Precedence: precedence,
+		Token:      token,
+	}
+}
+
+func (o Operator) ToString() string {
+	switch o.Fixity {
+	case Prefix:
+		return fmt.Sprintf("PREFIX(%d, %s)", o.Precedence, o.Token.ToString())
+	case Postfix:
+		return fmt.Sprintf("POSTFIX(%d, %s)", o.Precedence, o.Token.ToString())
+	}
+	assoc := [...]string{"left", "right", "none"}[o.Associativity]
+	return fmt.Sprintf("INFIX(%d, %s, %s)", o.Precedence, assoc, o.Token.ToString())
+}
+
+type OperatorsLexer struct {
+	operand Lexer
+	space   Lexer
+	table   []Operator
+
+	prefix  []Operator
+	infix   []Operator
+	postfix []Operator
+}
+
+// Operators lexes operands combined by the operators in the given
+// table, using precedence climbing. Every operator application is
+// returned as an "infix", "prefix" or "postfix" node, with groups
+// "left", "operator" and "right" for its parts. Where more than one
+// operator matches, the longest match wins, so "**" isn't taken for
+// "*". The space lexer, if not nil, is skipped around infix operators,
+// after prefix operators and before postfix operators.
+func Operators(operand Lexer, space Lexer, table ...Operator) Lexer {
+	p := &OperatorsLexer{
+		operand: operand,
+		space:   space,
+		table:   table,
+	}
+	for _, op := range table {
+		switch op.Fixity {
+		case Prefix:
+			p.prefix = append(p.prefix, op)
+		case Infix:
+			p.infix = append(p.infix, op)
+		case Postfix:
+			p.postfix = append(p.postfix, op)
+		}
+	}
+	return p
+}
+
+func (p *OperatorsLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	node, err := p.lexExpression(s, 0)
+	if err != nil {
+		err.Trace(s, p)
+	}
+	return node, err
+}
+
+// lexExpression lexes an expression made up of operators that have
+// at least the given precedence.
+func (p *OperatorsLexer) lexExpression(s *Scanner, min int) (*LexNode, *LexError) {
+	start := s.Position()
+
+	// Start with an operand, or a prefix operator applied to one
+	s.Push()
+	left, err := p.lexPrefix(s)
+	if err != nil && (err.committed || s.Committed()) {
+		s.Pop()
+		err.committed = true
+		return nil, err
+	}
+	if left != nil {
+		s.Discard()
+	} else {
+		s.Pop()
+		left, err = p.operand.Lex(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Then apply operators to it for as long as they bind tightly
+	// enough. A non-associative operator may not directly follow
+	// another one of the same precedence.
+	blocked := -1
+	for {
+		s.Push()
+		op, opNode, err := p.lexOperator(s, p.postfix, true, false)
+		if err != nil {
+			s.Pop()
+			return nil, err
+		}
+		if op != nil && op.Precedence >= min {
+			s.Discard()
+			left = p.node(s, "postfix", start, wrap("left", left), opNode)
+			continue
+		}
+		s.Pop()
+
+		s.Push()
+		op, opNode, err = p.lexOperator(s, p.infix, true, true)
+		if err != nil {
+			s.Pop()
+			return nil, err
+		}
+		if op == nil || op.Precedence < min || op.Precedence == blocked {
+			s.Pop()
+			break
+		}
+
+		next := op.Precedence + 1
+		if op.Associativity == RightAssoc {
+			next = op.Precedence
+		}
+		right, err := p.lexExpression(s, next)
+		if err != nil {
+			committed := err.committed || s.Committed()
+			s.Pop()
+			if committed {
+				err.committed = true
+				return nil, err
+			}
+			break
+		}
+		s.Discard()
+
+		left = p.node(s, "infix", start,
+			wrap("left", left), opNode, wrap("right", right))
+		blocked = -1
+		if op.Associativity == NonAssoc {
+			blocked = op.Precedence
+		}
+	}
+
+	return left, nil
+}
+
+// lexPrefix lexes a prefix operator and its operand. If there is no
+// prefix operator, it returns neither a node nor an error.
+func (p *OperatorsLexer) lexPrefix(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	op, opNode, err := p.lexOperator(s, p.prefix, false, true)
+	if op == nil || err != nil {
+		return nil, err
+	}
+
+	right, err := p.lexExpression(s, op.Precedence)
+	if err != nil {
+		return nil, err
+	}
+	return p.node(s, "prefix", start, opNode, wrap("right", right)), nil
+}
+
+// lexOperator lexes the longest matching operator out of ops, along
+// with the space before and/or after it. If none of them match, the
+// scanner is left where it was. Only committed errors are returned.
+func (p *OperatorsLexer) lexOperator(s *Scanner, ops []Operator, before bool, after bool) (*Operator, *LexNode, *LexError) {
+	var best *Operator
+	var bestNode *LexNode
+	var bestState scannerState
+
+	for i := range ops {
+		s.Push()
+		node, err := p.lexToken(s, ops[i].Token, before, after)
+		if err != nil && (err.committed || s.Committed()) {
+			s.Pop()
+			err.committed = true
+			return nil, nil, err
+		}
+		if err == nil && (best == nil || s.index > bestState.index) {
+			best = &ops[i]
+			bestNode = node
+			bestState = s.state()
+		}
+		s.Pop()
+	}
+
+	if best != nil {
+		s.restore(bestState)
+	}
+	return best, bestNode, nil
+}
+
+// lexToken lexes an operator token, and returns it in an "operator"
+// group. The space around it is kept in the group as well, so that
+// no comments get lost.
+func (p *OperatorsLexer) lexToken(s *Scanner, token Lexer, before bool, after bool) (*LexNode, *LexError) {
+	var nodes []*LexNode
+	if before && p.space != nil {
+		space, err := p.space.Lex(s)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, space)
+	}
+	node, err := token.Lex(s)
+	if err != nil {
+		return nil, err
+	}
+	nodes = append(nodes, node)
+	if after && p.space != nil {
+		space, err := p.space.Lex(s)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, space)
+	}
+
+	group := wrap("operator", node)
+	group.Children = nodes
+	return group, nil
+}
+
+func (p *OperatorsLexer) node(s *Scanner, name string, start Position, children ...*LexNode) *LexNode {
+	value, ignored := s.value(start, children)
+	return &LexNode{
+		Name:     name,
+		Value:    value,
+		ignored:  ignored,
+		Children: children,
+		Start:    start,
+		End:      s.Position(),
+	}
+}
+
+// wrap puts node in a group of its own, without touching the group
+// name it may already have.
+func wrap(group string, node *LexNode) *LexNode {
+	return &LexNode{
+		Name:      group,
+		GroupName: group,
+		Value:     node.Value,
+		ignored:   node.ignored,
+		Children:  []*LexNode{node},
+		Start:     node.Start,
+		End:       node.End,
+	}
+}
+
+func (p *OperatorsLexer) ToString() string {
+	rows := make([]string, len(p.table))
+	for i, op := range p.table {
+		rows[i] = op.ToString()
+	}
+	return fmt.Sprintf("OPERATORS(%s, %s)",
+		p.operand.ToString(), strings.Join(rows, ", "))
+}
@@ -0,0 +1,83 @@
+package lexer
+
+import (
+	"fmt"
+	"testing"
+)
+
+// renderOperators renders the operator nodes in a tree with explicit
+// parentheses, e.g. "(1 + (2 * 3))".
+func renderOperators(node *LexNode) string {
+	switch node.Name {
+	case "infix":
+		return fmt.Sprintf("(%s %s %s)",
+			renderOperators(node.GroupNode("left").Children[0]),
+			node.GroupNode("operator").Value,
+			renderOperators(node.GroupNode("right").Children[0]))
+	case "prefix":
+		return fmt.Sprintf("(%s%s)",
+			node.GroupNode("operator").Value,
+			renderOperators(node.GroupNode("right").Children[0]))
+	case "postfix":
+		return fmt.Sprintf("(%s%s)",
+			renderOperators(node.GroupNode("left").Children[0]),
+			node.GroupNode("operator").Value)
+	}
+	return node.Value
+}
+
+func TestOperatorsLexer(t *testing.T) {
+
+	lex := And(Operators(Regex("[a-z0-9]+", false), Regex(" *", true),
+		InfixOp(1, RightAssoc, Atom("=")),
+		InfixOp(2, NonAssoc, Atom("<")),
+		InfixOp(3, LeftAssoc, Or(Atom("+"), Atom("-"))),
+		InfixOp(4, LeftAssoc, Atom("*")),
+		PrefixOp(5, Atom("-")),
+		InfixOp(6, RightAssoc, Atom("**")),
+		PostfixOp(7, Atom("!")),
+	), EOF())
+
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{"a", "a", ""},
+		{"1 + 2 * 3", "(1 + (2 * 3))", ""},
+		{"1 - 2 - 3", "((1 - 2) - 3)", ""},
+		{"a = b = c", "(a = (b = c))", ""},
+		{"2 ** 3 ** 2", "(2 ** (3 ** 2))", ""},
+		{"2 * 3 ** 2", "(2 * (3 ** 2))", ""},
+		{"-2 ** 2", "(-(2 ** 2))", ""},
+		{"2 ** -2", "(2 ** (-2))", ""},
+		{"--a*b", "((-(-a)) * b)", ""},
+		{"n! * 2", "((n!) * 2)", ""},
+		{"-n!", "(-(n!))", ""},
+		{"a < b", "(a < b)", ""},
+		{"a < b < c", "", `1:7: expected one of "!", "=", "+", "-", "*", "**"`},
+		{"1 +", "", `1:4: expected one of "-", /[a-z0-9]+/`},
+	}
+
+	for i, tt := range tests {
+		node, err := lex.Lex(NewScanner(tt.input))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("TestOperatorsLexer[%d]: expected error=%q got=%v",
+					i, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestOperatorsLexer[%d]: unexpected error=%s", i, err.Error())
+		}
+		if got := renderOperators(node.Children[0]); got != tt.expected {
+			t.Fatalf("TestOperatorsLexer[%d]: expected=%s got=%s",
+				i, tt.expected, got)
+		}
+		if node.Children[0].Value != tt.input {
+			t.Fatalf("TestOperatorsLexer[%d]: expected value=%q got=%q",
+				i, tt.input, node.Children[0].Value)
+		}
+	}
+}
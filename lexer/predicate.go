@@ -0,0 +1,161 @@
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type PeekLexer struct {
+	child Lexer
+}
+
+// Peek matches wherever child matches, without consuming any input.
+// A Cut within child only applies to child itself.
+func Peek(child Lexer) Lexer {
+	return &PeekLexer{child: child}
+}
+
+func (p *PeekLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	failures := s.saveFailures()
+
+	s.Push()
+	_, lexErr := p.child.Lex(s)
+	s.Pop()
+	if lexErr != nil {
+		lexErr.committed = false
+		lexErr.Trace(s, p)
+		return nil, lexErr
+	}
+
+	// Whatever failed while looking ahead doesn't matter anymore
+	s.restoreFailures(failures)
+
+	return &LexNode{
+		Name:  "peek",
+		Start: start,
+		End:   start,
+	}, nil
+}
+
+func (p *PeekLexer) ToString() string {
+	return fmt.Sprintf("PEEK(%s)", p.child.ToString())
+}
+
+type NotLexer struct {
+	child Lexer
+}
+
+// Not matches wherever child does not match, without consuming any
+// input. A Cut within child only applies to child itself.
+func Not(child Lexer) Lexer {
+	return &NotLexer{child: child}
+}
+
+func (p *NotLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	failures := s.saveFailures()
+
+	s.Push()
+	_, lexErr := p.child.Lex(s)
+	s.Pop()
+
+	// Neither the failures of child, nor its success,
+	// say anything about what was expected here.
+	s.restoreFailures(failures)
+	if lexErr == nil {
+		return nil, err(s, fmt.Sprintf("Unexpected %s", p.child.ToString()), p)
+	}
+
+	return &LexNode{
+		Name:  "not",
+		Start: start,
+		End:   start,
+	}, nil
+}
+
+func (p *NotLexer) ToString() string {
+	return fmt.Sprintf("NOT(%s)", p.child.ToString())
+}
+
+type KeywordLexer struct {
+	word  string
+	lexer Lexer
+}
+
+// Keyword matches word, unless it is directly followed by something
+// matching wordChar, in which case it's only the start of a longer
+// word. For example, Keyword("let", Regex("[a-z]", false)) matches
+// the start of "let x", but not that of "letter".
+func Keyword(word string, wordChar Lexer) Lexer {
+	return &KeywordLexer{
+		word:  word,
+		lexer: And(Atom(word), Not(wordChar)),
+	}
+}
+
+func (p *KeywordLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	_, lexErr := p.lexer.Lex(s)
+	if lexErr != nil {
+		lexErr.Trace(s, p)
+		return nil, lexErr
+	}
+
+	return &LexNode{
+		Name:  "keyword",
+		Value: p.word,
+		Start: start,
+		End:   s.Position(),
+	}, nil
+}
+
+func (p *KeywordLexer) ToString() string {
+	return fmt.Sprintf("KEYWORD(%s)", strconv.Quote(p.word))
+}
+
+type ReservedLexer struct {
+	child Lexer
+	words map[string]bool
+	list  []string
+}
+
+// Reserved matches whatever child matches, unless that is one of the
+// given words. This keeps identifiers from matching keywords.
+func Reserved(child Lexer, words ...string) Lexer {
+	p := &ReservedLexer{
+		child: child,
+		words: make(map[string]bool, len(words)),
+		list:  words,
+	}
+	for _, word := range words {
+		p.words[word] = true
+	}
+	return p
+}
+
+func (p *ReservedLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.state()
+	node, lexErr := p.child.Lex(s)
+	if lexErr != nil {
+		lexErr.Trace(s, p)
+		return nil, lexErr
+	}
+
+	if p.words[node.Value] {
+		s.restore(start)
+		s.expect("non-reserved word")
+		return nil, err(s, fmt.Sprintf("Reserved word: %s", node.Value), p)
+	}
+	return node, nil
+}
+
+func (p *ReservedLexer) ToString() string {
+	quoted := make([]string, len(p.list))
+	for i, word := range p.list {
+		quoted[i] = strconv.Quote(word)
+	}
+	return fmt.Sprintf("RESERVED(%s, %s)",
+		p.child.ToString(), strings.Join(quoted, ", "))
+}
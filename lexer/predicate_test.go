@@ -0,0 +1,47 @@
+package lexer
+
+import "testing"
+
+func TestPredicateLexer(t *testing.T) {
+
+	word := Regex("[a-z]", false)
+	ident := Group("identifier", Reserved(Regex("[a-z]+", false), "let", "fn"))
+	tests := []struct {
+		input    string
+		lex      Lexer
+		expected string
+		index    int
+		err      string
+	}{
+		{"ab", And(Peek(Atom("ab")), Atom("a")), "a", 1, ""},
+		{"ab", And(Peek(Atom("b")), Atom("a")), "", 0, `1:1: expected "b"`},
+		{"ab", And(Not(Atom("b")), Atom("a")), "a", 1, ""},
+		{"ab", And(Not(Atom("a")), Atom("ab")), "", 0, "1:1: Unexpected ATOM(\"a\")"},
+		{"ab", Or(And(Peek(And(Atom("a"), Cut(), Atom("c"))), Atom("x")), Atom("ab")), "ab", 2, ""},
+		{"let x", Keyword("let", word), "let", 3, ""},
+		{"letter", Or(Keyword("let", word), ident), "letter", 6, ""},
+		{"let", And(Keyword("let", word), EOF()), "let", 3, ""},
+		{"fnord", ident, "fnord", 5, ""},
+		{"fn", ident, "", 0, "1:1: expected identifier"},
+		{"let x", And(ident, Atom(" ")), "", 0, "1:1: expected identifier"},
+	}
+
+	for i, tt := range tests {
+		s := NewScanner(tt.input)
+		node, err := tt.lex.Lex(s)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("TestPredicateLexer[%d]: expected error=%q got=%v",
+					i, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestPredicateLexer[%d]: unexpected error=%s", i, err.Error())
+		}
+		if node.Value != tt.expected || s.index != tt.index {
+			t.Fatalf("TestPredicateLexer[%d]: expected=%s (%d) got=%s (%d)",
+				i, tt.expected, tt.index, node.Value, s.index)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package lexer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Diagram is the railroad diagram of a single grammar rule, as
+// returned by Railroad.
+type Diagram struct {
+	Rule string
+	SVG  string
+}
+
+// Railroad returns a railroad diagram for every rule of the grammar
+// rooted at root, in the same order as EBNF lists them. Each diagram
+// is a self-contained SVG document. Terminals are drawn in rounded
+// boxes, references to other rules in square ones, and whatever has
+// no notation of its own, such as the end of input, in dashed ones.
+func Railroad(root Lexer) []Diagram {
+	var diagrams []Diagram
+	for _, rule := range grammarRules(root) {
+		diagrams = append(diagrams, Diagram{
+			Rule: rule.name,
+			SVG:  railroadDiagram(railroad(rule.body)),
+		})
+	}
+	return diagrams
+}
+
+// Sizes used to lay out railroad diagrams, in pixels
+const (
+	rrCharWidth = 8.5  // width of a character of text
+	rrBoxHeight = 22.0 // height of a box
+	rrPadding   = 10.0 // space around text in a box
+	rrGap       = 10.0 // space between boxes
+	rrArc       = 20.0 // space for the curves of branches and loops
+	rrMargin    = 20.0 // space around the diagram
+)
+
+type rrKind int
+
+const (
+	rrTerminal rrKind = iota
+	rrRule
+	rrSpecial
+	rrSkip
+	rrSequence
+	rrChoice
+	rrLoop
+)
+
+// rrItem is an element of a railroad diagram. Items are drawn along
+// a horizontal line, which they extend up and down from.
+type rrItem struct {
+	kind  rrKind
+	text  string
+	items []*rrItem
+
+	width float64
+	up    float64
+	down  float64
+}
+
+func rrBox(kind rrKind, text string) *rrItem {
+	return &rrItem{
+		kind:  kind,
+		text:  text,
+		width: float64(utf8.RuneCountInString(text))*rrCharWidth + 2*rrPadding,
+		up:    rrBoxHeight / 2,
+		down:  rrBoxHeight / 2,
+	}
+}
+
+func rrSequenceOf(items ...*rrItem) *rrItem {
+	var kept []*rrItem
+	for _, item := range items {
+		if item.kind != rrSkip {
+			kept = append(kept, item)
+		}
+	}
+	switch len(kept) {
+	case 0:
+		return &rrItem{kind: rrSkip}
+	case 1:
+		return kept[0]
+	}
+
+	seq := &rrItem{kind: rrSequence, items: kept}
+	for i, item := range kept {
+		if i > 0 {
+			seq.width += rrGap
+		}
+		seq.width += item.width
+		seq.up = math.Max(seq.up, item.up)
+		seq.down = math.Max(seq.down, item.down)
+	}
+	return seq
+}
+
+// rrChoiceOf stacks the alternatives, with the first on the line.
+func rrChoiceOf(items ...*rrItem) *rrItem {
+	if len(items) == 1 {
+		return items[0]
+	}
+
+	choice := &rrItem{kind: rrChoice, items: items}
+	for i, item := range items {
+		choice.width = math.Max(choice.width, item.width+2*rrArc)
+		if i == 0 {
+			choice.up = item.up
+			choice.down = item.down
+		} else {
+			choice.down += rrGap + item.up + item.down
+		}
+	}
+	return choice
+}
+
+func rrOptional(item *rrItem) *rrItem {
+	return rrChoiceOf(item, &rrItem{kind: rrSkip})
+}
+
+// rrLoopOf matches item one or more times, with separator in between.
+func rrLoopOf(item *rrItem, separator *rrItem) *rrItem {
+	return &rrItem{
+		kind:  rrLoop,
+		items: []*rrItem{item, separator},
+		width: math.Max(item.width, separator.width) + 2*rrArc,
+		up:    item.up,
+		down:  item.down + rrGap + separator.up + separator.down,
+	}
+}
+
+// railroad lays out l, in the same way as ebnf writes it out.
+func railroad(l Lexer) *rrItem {
+	switch p := unwrap(l).(type) {
+
+	case nil:
+		return rrBox(rrSpecial, "undefined")
+	case *FutureLexer:
+		return rrBox(rrRule, p.name)
+	case *CutLexer:
+		return &rrItem{kind: rrSkip}
+
+	// Terminals
+	case *AtomLexer:
+		return rrBox(rrTerminal, strconv.Quote(p.atom))
+	case *KeywordLexer:
+		return rrBox(rrTerminal, strconv.Quote(p.word))
+	case *RuneLexer:
+		return rrBox(rrTerminal, strconv.Quote(string(p.r)))
+	case *RegexLexer:
+		return rrBox(rrTerminal, "/"+p.pattern+"/")
+	case *RuneClassLexer:
+		return rrBox(rrSpecial, "class("+strings.Join(p.names(), ", ")+")")
+	case *EOFLexer:
+		return rrBox(rrSpecial, "end of input")
+	case *IndentLexer:
+		return rrBox(rrSpecial, "indent")
+	case *SameIndentLexer:
+		return rrBox(rrSpecial, "same indent")
+	case *DedentLexer:
+		return rrBox(rrSpecial, "dedent")
+	case *BlockCommentLexer:
+		return rrSequenceOf(
+			rrBox(rrTerminal, strconv.Quote(p.open)),
+			rrBox(rrSpecial, "nested comment text"),
+			rrBox(rrTerminal, strconv.Quote(p.close)),
+		)
+	case *LineCommentLexer:
+		return rrSequenceOf(
+			rrBox(rrTerminal, strconv.Quote(p.prefix)),
+			rrBox(rrSpecial, "rest of line"),
+		)
+
+	case *AndLexer:
+		items := make([]*rrItem, len(p.children))
+		for i, child := range p.children {
+			items[i] = railroad(child)
+		}
+		return rrSequenceOf(items...)
+
+	case *OrLexer:
+		items := make([]*rrItem, len(p.children))
+		for i, child := range p.children {
+			items[i] = railroad(child)
+		}
+		return rrChoiceOf(items...)
+
+	case *RepeatLexer:
+		item := railroad(p.child)
+		switch {
+		case p.max == 1:
+			return rrOptional(item)
+		case p.min == 0:
+			return rrOptional(rrLoopOf(item, &rrItem{kind: rrSkip}))
+		}
+		return rrLoopOf(item, &rrItem{kind: rrSkip})
+
+	case *InterlaceLexer:
+		return rrLoopOf(railroad(p.outer), railroad(p.inner))
+
+	// Whatever has no shape of its own is written out as EBNF
+	case *PeekLexer, *NotLexer, *ReservedLexer:
+		str, _ := ebnf(p)
+		return rrBox(rrSpecial, str)
+
+	case *TriviaLexer:
+		items := make([]*rrItem, len(p.alternatives))
+		for i, child := range p.alternatives {
+			items[i] = railroad(child)
+		}
+		loop := rrLoopOf(rrChoiceOf(items...), &rrItem{kind: rrSkip})
+		if p.allowEmpty {
+			return rrOptional(loop)
+		}
+		return loop
+
+	case *OperatorsLexer:
+		return railroadOperators(p)
+	}
+
+	return rrBox(rrSpecial, l.ToString())
+}
+
+// railroadOperators lays out an operator table as a loop over the
+// operands, passing through the infix operators.
+func railroadOperators(p *OperatorsLexer) *rrItem {
+	space := &rrItem{kind: rrSkip}
+	if p.space != nil {
+		space = railroad(p.space)
+	}
+	tokens := func(ops []Operator) *rrItem {
+		var items []*rrItem
+		for _, op := range ops {
+			if or, ok := unwrap(op.Token).(*OrLexer); ok {
+				for _, child := range or.children {
+					items = append(items, railroad(child))
+				}
+				continue
+			}
+			items = append(items, railroad(op.Token))
+		}
+		return rrChoiceOf(items...)
+	}
+
+	operand := railroad(p.operand)
+	if len(p.prefix) > 0 {
+		prefix := rrSequenceOf(tokens(p.prefix), space)
+		operand = rrSequenceOf(rrOptional(rrLoopOf(prefix, &rrItem{kind: rrSkip})), operand)
+	}
+	if len(p.postfix) > 0 {
+		postfix := rrSequenceOf(space, tokens(p.postfix))
+		operand = rrSequenceOf(operand, rrOptional(rrLoopOf(postfix, &rrItem{kind: rrSkip})))
+	}
+	if len(p.infix) == 0 {
+		return operand
+	}
+	return rrLoopOf(operand, rrSequenceOf(space, tokens(p.infix), space))
+}
+
+// railroadDiagram draws item as an SVG document.
+func railroadDiagram(item *rrItem) string {
+	width := item.width + 2*rrMargin + 2*rrGap
+	height := item.up + item.down + 2*rrMargin
+	y := rrMargin + item.up
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g">`,
+		width, height, width, height)
+	b.WriteString("\n<style>" +
+		"path{fill:none;stroke:#333;stroke-width:1.5}" +
+		"rect{fill:#fff;stroke:#333;stroke-width:1.5}" +
+		"rect.terminal{fill:#ffc}" +
+		"rect.rule{fill:#def}" +
+		"rect.special{stroke-dasharray:4 2}" +
+		"text{font:14px monospace;text-anchor:middle;dominant-baseline:central}" +
+		"</style>\n")
+
+	// Start and end with a bar across the line
+	x := rrMargin
+	fmt.Fprintf(&b, `<path d="M%g %gv%g"/>`+"\n", x, y-rrBoxHeight/2, rrBoxHeight)
+	rrLine(&b, x, y, x+rrGap)
+	rrDraw(&b, item, x+rrGap, y)
+	x += rrGap + item.width
+	rrLine(&b, x, y, x+rrGap)
+	fmt.Fprintf(&b, `<path d="M%g %gv%g"/>`+"\n", x+rrGap, y-rrBoxHeight/2, rrBoxHeight)
+
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+func rrLine(b *strings.Builder, x1 float64, y float64, x2 float64) {
+	if x2 > x1 {
+		fmt.Fprintf(b, `<path d="M%g %gH%g"/>`+"\n", x1, y, x2)
+	}
+}
+
+// rrCurve draws a curve from (x1, y1) to (x2, y2), leaving and
+// arriving horizontally.
+func rrCurve(b *strings.Builder, x1 float64, y1 float64, x2 float64, y2 float64) {
+	mx := (x1 + x2) / 2
+	fmt.Fprintf(b, `<path d="M%g %gC%g %g %g %g %g %g"/>`+"\n",
+		x1, y1, mx, y1, mx, y2, x2, y2)
+}
+
+// rrTurn draws a curve from (x1, y1) to (x1, y2) that bulges out
+// to x2, for loops to turn around.
+func rrTurn(b *strings.Builder, x1 float64, y1 float64, x2 float64, y2 float64) {
+	fmt.Fprintf(b, `<path d="M%g %gC%g %g %g %g %g %g"/>`+"\n",
+		x1, y1, x2, y1, x2, y2, x1, y2)
+}
+
+var rrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// rrDraw draws item with its line starting at (x, y).
+func rrDraw(b *strings.Builder, item *rrItem, x float64, y float64) {
+	switch item.kind {
+
+	case rrTerminal, rrRule, rrSpecial:
+		class := [...]string{"terminal", "rule", "special"}[item.kind]
+		rx := 0.0
+		if item.kind == rrTerminal {
+			rx = rrBoxHeight / 2
+		}
+		fmt.Fprintf(b, `<rect class="%s" x="%g" y="%g" width="%g" height="%g" rx="%g"/>`+"\n",
+			class, x, y-item.up, item.width, rrBoxHeight, rx)
+		fmt.Fprintf(b, `<text x="%g" y="%g">%s</text>`+"\n",
+			x+item.width/2, y, rrEscaper.Replace(item.text))
+
+	case rrSequence:
+		for i, child := range item.items {
+			if i > 0 {
+				rrLine(b, x, y, x+rrGap)
+				x += rrGap
+			}
+			rrDraw(b, child, x, y)
+			x += child.width
+		}
+
+	case rrChoice:
+		right := x + item.width
+		childY := y
+		for i, child := range item.items {
+			if i > 0 {
+				childY += item.items[i-1].down + rrGap + child.up
+				rrCurve(b, x, y, x+rrArc, childY)
+				rrCurve(b, right-rrArc, childY, right, y)
+			} else {
+				rrLine(b, x, y, x+rrArc)
+				rrLine(b, right-rrArc, y, right)
+			}
+			rrDraw(b, child, x+rrArc, childY)
+			rrLine(b, x+rrArc+child.width, childY, right-rrArc)
+		}
+
+	case rrLoop:
+		child, separator := item.items[0], item.items[1]
+		right := x + item.width
+		loopY := y + child.down + rrGap + separator.up
+
+		rrLine(b, x, y, x+rrArc)
+		rrDraw(b, child, x+rrArc, y)
+		rrLine(b, x+rrArc+child.width, y, right)
+
+		// Back from the end to the start, through the separator
+		rrTurn(b, right-rrArc, y, right, loopY)
+		rrDraw(b, separator, x+rrArc, loopY)
+		rrLine(b, x+rrArc+separator.width, loopY, right-rrArc)
+		rrTurn(b, x+rrArc, loopY, x, y)
+	}
+}
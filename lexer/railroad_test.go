@@ -0,0 +1,61 @@
+package lexer
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRailroad(t *testing.T) {
+
+	var expr Lexer
+	lExpr := Future(&expr, "expr")
+	expr = Or(
+		And(Atom("("), lExpr, Atom(")")),
+		Interlace(Regex("[0-9]+", false), Atom("+")),
+		Optional(And(Atom("<"), EOF())),
+	)
+
+	diagrams := Railroad(And(lExpr, EOF()))
+	tests := []struct {
+		rule  string
+		boxes []string
+	}{
+		{"grammar", []string{"expr", "end of input"}},
+		{"expr", []string{`"("`, "expr", `")"`, "/[0-9]+/", `"+"`, `"&lt;"`, "end of input"}},
+	}
+
+	if len(diagrams) != len(tests) {
+		t.Fatalf("TestRailroad: expected %d diagrams, got=%d", len(tests), len(diagrams))
+	}
+	for i, tt := range tests {
+		diagram := diagrams[i]
+		if diagram.Rule != tt.rule {
+			t.Fatalf("TestRailroad[%d]: expected rule=%q got=%q", i, tt.rule, diagram.Rule)
+		}
+
+		// Every diagram must be a well-formed document on its own
+		decoder := xml.NewDecoder(strings.NewReader(diagram.SVG))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("TestRailroad[%d]: invalid SVG: %s", i, err.Error())
+			}
+		}
+
+		var boxes []string
+		for _, line := range strings.Split(diagram.SVG, "\n") {
+			if strings.HasPrefix(line, "<text") {
+				start := strings.Index(line, ">") + 1
+				boxes = append(boxes, line[start:strings.LastIndex(line, "<")])
+			}
+		}
+		if strings.Join(boxes, " ") != strings.Join(tt.boxes, " ") {
+			t.Fatalf("TestRailroad[%d]: expected boxes=%q got=%q", i, tt.boxes, boxes)
+		}
+	}
+}
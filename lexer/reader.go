@@ -0,0 +1,111 @@
+package lexer
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// readSize is the minimum number of bytes read from a reader at once,
+// and the minimum number of bytes released at once.
+const readSize = 4096
+
+// NewReaderScanner returns a scanner that reads its input from r as
+// far as needed, rather than all at once. Input before the current
+// line is released whenever the push/pop stack is empty, as nothing
+// can backtrack to it anymore.
+func NewReaderScanner(r io.Reader) *Scanner {
+	s := NewScanner("")
+	s.reader = r
+	return s
+}
+
+// Err returns the first error returned by the reader of this
+// scanner, other than io.EOF. The scanner treats such an error
+// as the end of the input.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// fill reads until at least n bytes are available after the current
+// index, or there is nothing left to read. A negative n reads all of
+// the input. It returns whether n bytes are available.
+func (s *Scanner) fill(n int) bool {
+	for s.reader != nil && !s.eof && (n < 0 || s.end()-s.index < n) {
+
+		// Append to the input in ever larger chunks, so that it
+		// doesn't take quadratic time, however little each read
+		// returns. The chunk is reused until it is outgrown.
+		size := readSize
+		if len(s.input) > size {
+			size = len(s.input)
+		}
+		if cap(s.chunk) < size {
+			s.chunk = make([]byte, size)
+		}
+		chunk := s.chunk[:0]
+		for len(chunk) < size && !s.eof {
+			count, err := s.reader.Read(chunk[len(chunk):size])
+			chunk = chunk[:len(chunk)+count]
+			if err == io.EOF {
+				s.eof = true
+			} else if err != nil {
+				s.eof = true
+				s.err = err
+			}
+		}
+		s.input += string(chunk)
+	}
+	if n < 0 {
+		s.examine(s.end() + 1)
+	} else {
+		s.examine(s.index + n)
+	}
+	return n >= 0 && s.end()-s.index >= n
+}
+
+// release drops all input before the start of the current line, if
+// it is large enough to be worth it. Keeping the current line around
+// allows columns to be counted from its start.
+func (s *Scanner) release() {
+	if s.reader == nil || s.pinned > 0 {
+		return
+	}
+
+	cut := s.lines[s.lineNumber-1]
+	if cut-s.base < readSize || cut-s.base < len(s.input)/2 {
+		return
+	}
+
+	// Released input can no longer be validated later on
+	s.Validate()
+	if s.validated < cut && s.invalid == nil {
+		return
+	}
+
+	s.input = strings.Clone(s.slice(cut, s.end()))
+	s.base = cut
+	for key := range s.memo {
+		if key.index < cut {
+			delete(s.memo, key)
+		}
+	}
+}
+
+// scannerReader reads runes from the input of a scanner, reading
+// more from its reader as needed. It allows regular expressions to
+// match on input that wasn't read yet.
+type scannerReader struct {
+	s      *Scanner
+	offset int
+}
+
+func (r *scannerReader) ReadRune() (rune, int, error) {
+	r.s.fill(r.offset - r.s.index + utf8.UTFMax)
+	if r.offset >= r.s.end() {
+		return 0, 0, io.EOF
+	}
+	ch, size := utf8.DecodeRuneInString(r.s.slice(r.offset, r.s.end()))
+	r.offset += size
+	return ch, size, nil
+}
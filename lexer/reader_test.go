@@ -0,0 +1,135 @@
+package lexer
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode"
+)
+
+func TestReaderScanner(t *testing.T) {
+
+	word := Regex("[a-z]+", false)
+	tests := []struct {
+		lexer Lexer
+		input string
+	}{
+		{And(Atom("let"), Atom(" "), word, EOF()), "let abc"},
+		{And(Atom("let"), Atom(" "), word, EOF()), "let abc def"},
+		{Repeat(Or(word, Atom(" "), Atom("\n")), 0, -1), "one two\nthree\n"},
+		{And(Repeat(RuneClass(unicode.L), 1, -1), EOF()), "ßäöü漢字"},
+		{And(Rune('ä'), Rune('漢'), EOF()), "ä漢x"},
+		{Interlace(Group("word", word), Ignore(Atom("\n"))), "a\nbb\nccc"},
+	}
+
+	for i, tt := range tests {
+		expectedNode, expectedErr := tt.lexer.Lex(NewScanner(tt.input))
+
+		s := NewReaderScanner(iotest.OneByteReader(strings.NewReader(tt.input)))
+		node, err := tt.lexer.Lex(s)
+		if (err == nil) != (expectedErr == nil) {
+			t.Fatalf("TestReaderScanner[%d]: got error=%v expected=%v",
+				i, err, expectedErr)
+		}
+		if err != nil && err.Error() != expectedErr.Error() {
+			t.Fatalf("TestReaderScanner[%d]: got error=%q expected=%q",
+				i, err.Error(), expectedErr.Error())
+		}
+		if err == nil && node.String(0) != expectedNode.String(0) {
+			t.Fatalf("TestReaderScanner[%d]: got=%s expected=%s",
+				i, node.String(0), expectedNode.String(0))
+		}
+	}
+}
+
+func TestReaderRelease(t *testing.T) {
+
+	input := strings.Repeat("abc def\n", 4095) + "abc def"
+	lexer := And(Interlace(Group("line", Atom("abc def")), Atom("\n")), EOF())
+
+	for _, memo := range []bool{false, true} {
+		s := NewReaderScanner(iotest.HalfReader(strings.NewReader(input)))
+		if memo {
+			s.EnableMemo()
+		}
+
+		node, err := lexer.Lex(s)
+		if err != nil {
+			t.Fatalf("TestReaderRelease[memo=%v]: got error=%s", memo, err.Error())
+		}
+		if node.Value != input {
+			t.Fatalf("TestReaderRelease[memo=%v]: got value of length %d, expected %d",
+				memo, len(node.Value), len(input))
+		}
+		if len(s.input) >= len(input)/2 {
+			t.Fatalf("TestReaderRelease[memo=%v]: still holding %d bytes of input",
+				memo, len(s.input))
+		}
+		if pos := s.Position(); pos.Line != 4096 || pos.Column != 8 {
+			t.Fatalf("TestReaderRelease[memo=%v]: got position=%s", memo, pos)
+		}
+	}
+}
+
+func TestReaderOneByte(t *testing.T) {
+
+	// Reading byte by byte must not copy the input for each byte
+	input := strings.Repeat("abc def\n", 1<<13)
+	s := NewReaderScanner(iotest.OneByteReader(strings.NewReader(input)))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	remaining := s.Remaining()
+	runtime.ReadMemStats(&after)
+
+	if remaining != len(input) {
+		t.Fatalf("TestReaderOneByte: got remaining=%d expected=%d", remaining, len(input))
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8*uint64(len(input)) {
+		t.Fatalf("TestReaderOneByte: allocated %d bytes for %d bytes of input",
+			allocated, len(input))
+	}
+}
+
+func TestReaderValidate(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"ä漢\nß", ""},
+		{strings.Repeat("ab\n", 4096) + "\xff", "4097:1: Invalid UTF-8 encoding"},
+		{"ab\n\xffcd" + strings.Repeat("\nab", 4096), "2:1: Invalid UTF-8 encoding"},
+	}
+
+	lexer := Repeat(Or(Atom("ab"), Atom("\n"), Regex(".", false)), 0, -1)
+	for i, tt := range tests {
+		s := NewReaderScanner(iotest.OneByteReader(strings.NewReader(tt.input)))
+		lexer.Lex(s)
+		err := s.Validate()
+		if tt.expected == "" && err != nil {
+			t.Fatalf("TestReaderValidate[%d]: got error=%q", i, err.Error())
+		}
+		if tt.expected != "" && (err == nil || err.Error() != tt.expected) {
+			t.Fatalf("TestReaderValidate[%d]: got error=%v expected=%q",
+				i, err, tt.expected)
+		}
+	}
+}
+
+func TestReaderErr(t *testing.T) {
+
+	s := NewReaderScanner(iotest.DataErrReader(strings.NewReader("abc")))
+	_, err := And(Atom("abc"), EOF()).Lex(s)
+	if err != nil || s.Err() != nil {
+		t.Fatalf("TestReaderErr: got error=%v err=%v", err, s.Err())
+	}
+
+	readErr := errors.New("broken pipe")
+	s = NewReaderScanner(iotest.ErrReader(readErr))
+	if s.Remaining() != 0 || s.Err() != readErr {
+		t.Fatalf("TestReaderErr: got remaining=%d err=%v", s.Remaining(), s.Err())
+	}
+}
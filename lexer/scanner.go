@@ -1,18 +1,72 @@
 package lexer
 
 import (
+	"fmt"
+	"io"
 	"regexp"
+	"sort"
 	"strings"
 )
 
+// Position is a location in the input of a Scanner.
+type Position struct {
+	Offset int `json:"offset"` // Byte offset, starting at 0
+	Line   int `json:"line"`   // Line number, starting at 1
+	Column int `json:"column"` // Column number, starting at 1
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 type Scanner struct {
 	input string
 	index int
 
+	// When reading from a reader, input only holds whatever
+	// was read but not released yet, starting at offset base.
+	reader  io.Reader
+	chunk   []byte
+	base    int
+	eof     bool
+	pinned  int
+	err     error
+	invalid *LexError
+
 	lineNumber int
 	lineIndex  int
 
+	// The indentation of the blocks the scanner is in,
+	// innermost first. See Indent.
+	indent *indentLevel
+
+	// Offsets at which each line starts, for all
+	// input up to (but excluding) offset scanned.
+	lines   []int
+	scanned int
+
+	// Columns are counted in this unit. The last computed
+	// column is cached, to count from there if possible.
+	unit        ColumnUnit
+	columnCache scannerState
+	validated   int
+
 	stack *scannerState
+	memo  map[memoKey]*memoEntry
+
+	heads    map[memoKey]*recursionHead
+	seedUses int
+
+	failure  scannerState
+	expected []string
+	frame    memoFrame
+
+	// Counts of what was lexed, see EnableCoverage.
+	coverage *Coverage
+
+	// How far the input was examined, see Checkpoint.
+	examined    int
+	checkpoints bool
 }
 
 type scannerState struct {
@@ -20,8 +74,10 @@ type scannerState struct {
 
 	lineNumber int
 	lineIndex  int
+	indent     *indentLevel
 
-	next *scannerState
+	committed bool
+	next      *scannerState
 }
 
 func NewScanner(input string) *Scanner {
@@ -29,44 +85,76 @@ func NewScanner(input string) *Scanner {
 		input: input,
 		index: 0,
 
-		lineNumber: 0,
-		lineIndex:  0,
+		lineNumber: 1,
+		lineIndex:  1,
+
+		lines:   []int{0},
+		scanned: 0,
+
+		failure: scannerState{index: -1},
 	}
 }
 
+// Remaining returns the number of bytes left in the input. When
+// reading from a reader, this requires reading all of it.
 func (s *Scanner) Remaining() int {
-	return len(s.input) - s.index
+	s.fill(-1)
+	return s.end() - s.index
 }
 
+// Remainder returns the rest of the input. When reading from
+// a reader, this requires reading all of it.
 func (s *Scanner) Remainder() string {
-	return s.input[s.index:]
+	s.fill(-1)
+	return s.slice(s.index, s.end())
 }
 
 func (s *Scanner) Forward(amount int) string {
+	s.fill(amount)
 	before := s.index
 	s.index += amount
-	if s.index > len(s.input) {
-		s.index = len(s.input)
+	if s.index > s.end() {
+		s.index = s.end()
 	}
 	s.updateLinePosition()
-	return s.input[before:s.index]
+	return s.slice(before, s.index)
 }
 
 func (s *Scanner) Backward(amount int) string {
 	before := s.index
 	s.index -= amount
-	if s.index < 0 {
-		s.index = 0
+	if s.index < s.base {
+		s.index = s.base
 	}
 	s.updateLinePosition()
-	return s.input[s.index:before]
+	return s.slice(s.index, before)
+}
+
+// slice returns the input between two offsets,
+// neither of which may have been released.
+func (s *Scanner) slice(from int, to int) string {
+	return s.input[from-s.base : to-s.base]
+}
+
+// end returns the offset up to which input is available.
+func (s *Scanner) end() int {
+	return s.base + len(s.input)
+}
+
+// atEnd returns whether all input has been consumed.
+func (s *Scanner) atEnd() bool {
+	return !s.fill(1)
 }
 
 func (s *Scanner) Push() bool {
+	if s.stack == nil {
+		s.release()
+	}
 	cur := &scannerState{
 		index:      s.index,
 		lineNumber: s.lineNumber,
 		lineIndex:  s.lineIndex,
+		indent:     s.indent,
 		next:       s.stack,
 	}
 	s.stack = cur
@@ -78,6 +166,7 @@ func (s *Scanner) Pop() bool {
 		s.index = s.stack.index
 		s.lineNumber = s.stack.lineNumber
 		s.lineIndex = s.stack.lineIndex
+		s.indent = s.stack.indent
 		s.stack = s.stack.next
 		return true
 	}
@@ -90,6 +179,149 @@ func (s *Scanner) Discard() {
 	}
 }
 
+// Position returns the current position of the scanner.
+func (s *Scanner) Position() Position {
+	return s.state().position()
+}
+
+func (st scannerState) position() Position {
+	return Position{
+		Offset: st.index,
+		Line:   st.lineNumber,
+		Column: st.lineIndex,
+	}
+}
+
+// Commit marks the most recently pushed state as committed. The
+// lexer that pushed it may no longer backtrack to try another
+// alternative, and has to report the failure instead.
+func (s *Scanner) Commit() {
+	if s.stack != nil {
+		s.stack.committed = true
+	}
+}
+
+// Committed returns whether the most recently pushed state was
+// committed to through Commit.
+func (s *Scanner) Committed() bool {
+	return s.stack != nil && s.stack.committed
+}
+
+// state returns a snapshot of the current position, without
+// touching the push/pop stack.
+func (s *Scanner) state() scannerState {
+	return scannerState{
+		index:      s.index,
+		lineNumber: s.lineNumber,
+		lineIndex:  s.lineIndex,
+		indent:     s.indent,
+	}
+}
+
+// restore moves the scanner back to a position previously
+// obtained through state.
+func (s *Scanner) restore(state scannerState) {
+	s.index = state.index
+	s.lineNumber = state.lineNumber
+	s.lineIndex = state.lineIndex
+	s.indent = state.indent
+}
+
+// expect records that the given token was expected at the current
+// index. Only the expectations at the farthest index are kept, as
+// those are the most useful ones to report on a syntax error.
+func (s *Scanner) expect(token string) {
+	if s.index < s.failure.index {
+		return
+	}
+	if s.index > s.failure.index {
+		s.failure = s.state()
+		s.expected = nil
+	}
+	for _, expected := range s.expected {
+		if expected == token {
+			return
+		}
+	}
+	s.expected = append(s.expected, token)
+}
+
+// failures is the farthest failure of a scanner, and what was
+// expected there.
+type failures struct {
+	failure  scannerState
+	expected []string
+	reset    bool
+}
+
+// saveFailures returns the farthest failure so far, so that what
+// fails after it may be forgotten again through restoreFailures.
+func (s *Scanner) saveFailures() failures {
+	return failures{
+		failure:  s.failure,
+		expected: s.expected[:len(s.expected):len(s.expected)],
+		reset:    s.frame.reset,
+	}
+}
+
+func (s *Scanner) restoreFailures(f failures) {
+	s.failure, s.expected, s.frame.reset = f.failure, f.expected, f.reset
+}
+
+// forgetFailures forgets all failures so far, which have been
+// reported already.
+func (s *Scanner) forgetFailures() {
+	s.failure = scannerState{index: -1}
+	s.expected = nil
+	s.frame.reset = true
+}
+
+// mergeFailures adds the failures in f to the ones so far, as if
+// they were expected after them.
+func (s *Scanner) mergeFailures(f failures) {
+	if f.failure.index < s.failure.index {
+		return
+	}
+	if f.failure.index > s.failure.index {
+		s.failure, s.expected = f.failure, f.expected
+		return
+	}
+	expected := s.expected[:len(s.expected):len(s.expected)]
+	for _, token := range f.expected {
+		found := false
+		for _, other := range expected {
+			found = found || other == token
+		}
+		if !found {
+			expected = append(expected, token)
+		}
+	}
+	s.expected = expected
+}
+
+// expectMark returns the number of expectations already recorded
+// at the given index, so that the ones added after it can be
+// replaced through relabel.
+func (s *Scanner) expectMark(index int) int {
+	if s.failure.index != index {
+		return 0
+	}
+	return len(s.expected)
+}
+
+// relabel replaces all expectations recorded at index since mark
+// with a single label.
+func (s *Scanner) relabel(index int, mark int, label string) {
+	if s.failure.index < index {
+		s.readFailure(index)
+	}
+	if s.failure.index != index {
+		return
+	}
+	s.expected = s.expected[:mark:mark]
+	s.expect(label)
+}
+
 func (s *Scanner) stackSize() int {
 	cur := s.stack
 	count := 0
@@ -103,11 +335,11 @@ func (s *Scanner) stackSize() int {
 }
 
 func (s *Scanner) MatchString(str string) int {
-	if s.Remaining() < len(str) {
+	if !s.fill(len(str)) {
 		return 0
 	}
 
-	if s.input[s.index:s.index+len(str)] == str {
+	if s.slice(s.index, s.index+len(str)) == str {
 		return len(str)
 	}
 	return 0
@@ -118,15 +350,14 @@ func (s *Scanner) ConsumeString(str string) string {
 	return s.Forward(length)
 }
 
+// compileAnchored compiles expr so that it only matches
+// at the start of the input.
+func compileAnchored(expr string) *regexp.Regexp {
+	return regexp.MustCompile("^(?:" + expr + ")")
+}
+
 func (s *Scanner) MatchRegex(expr string) int {
-	reg := regexp.MustCompile("^" + expr)
-	rem := s.Remainder()
-	match := reg.FindString(rem)
-	matchLen := len(match)
-	if rem[:matchLen] != match {
-		return 0
-	}
-	return matchLen
+	return s.MatchRegexp(compileAnchored(expr))
 }
 
 func (s *Scanner) ConsumeRegex(expr string) string {
@@ -134,27 +365,80 @@ func (s *Scanner) ConsumeRegex(expr string) string {
 	return s.Forward(length)
 }
 
+// MatchRegexp returns the length of the match of reg at the current
+// position. The expression must be anchored to the start of the
+// input, as done by Regex.
+func (s *Scanner) MatchRegexp(reg *regexp.Regexp) int {
+	var loc []int
+	if s.reader != nil && !s.eof || s.checkpoints {
+		loc = reg.FindReaderIndex(&scannerReader{s: s, offset: s.index})
+	} else {
+		s.examine(s.end() + 1)
+		loc = reg.FindStringIndex(s.slice(s.index, s.end()))
+	}
+	if loc == nil {
+		return 0
+	}
+	return loc[1]
+}
+
+func (s *Scanner) ConsumeRegexp(reg *regexp.Regexp) string {
+	length := s.MatchRegexp(reg)
+	return s.Forward(length)
+}
+
 func (s *Scanner) updateLinePosition() {
 
-	// target shouldnt be higher than len(s.input)
+	// target shouldnt be higher than the end of the input
 	target := s.index
-	if target > len(s.input) {
-		target = len(s.input)
+	if target > s.end() {
+		target = s.end()
 	}
 
-	// get everything up to the cursor (exclusive)
-	before := s.input[0:target]
+	position := s.positionAt(target)
+	s.lineNumber = position.Line
+	s.lineIndex = position.Column
+}
+
+// positionAt returns the position of the given offset,
+// which may not lie beyond the end of the input.
+func (s *Scanner) positionAt(target int) Position {
+
+	// find the last line that starts at or before the target
+	s.indexLines(target)
+	line := sort.Search(len(s.lines), func(i int) bool {
+		return s.lines[i] > target
+	}) - 1
 
-	// get the index where the current line started
-	lineStart := strings.LastIndex(before, "\n") + 1
-	if lineStart == -1 {
-		lineStart = 0
+	// count the columns from wherever is closest, and
+	// offset both by +1 for human readability
+	cache := s.columnCache
+	if cache.lineNumber != line+1 || cache.index > target {
+		cache = scannerState{
+			index:      s.lines[line],
+			lineNumber: line + 1,
+			lineIndex:  1,
+		}
 	}
+	cache.lineIndex += s.unit.count(s.slice(cache.index, target))
+	cache.index = target
+	s.columnCache = cache
 
-	// calculate line number and index,
-	// both offset by +1 for human readability
-	s.lineNumber = strings.Count(before, "\n") + 1
-	s.lineIndex = s.index - lineStart + 1
+	return cache.position()
+}
+
+// indexLines records where each line starts, for all
+// input up to target that wasn't indexed yet.
+func (s *Scanner) indexLines(target int) {
+	for s.scanned < target {
+		i := strings.IndexByte(s.slice(s.scanned, target), '\n')
+		if i < 0 {
+			s.scanned = target
+			return
+		}
+		s.scanned += i + 1
+		s.lines = append(s.lines, s.scanned)
+	}
 }
 
 func (s *Scanner) LineNumber() int {
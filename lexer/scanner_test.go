@@ -1,6 +1,10 @@
 package lexer
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
 
 func TestRemaining(t *testing.T) {
 
@@ -233,6 +237,10 @@ func TestLinePosition(t *testing.T) {
 		s.index = tt.index
 		s.updateLinePosition()
 
+		// Moving back and forth shouldn't change anything
+		s.Forward(len(tt.input))
+		s.Backward(len(tt.input) - tt.index)
+
 		if s.lineNumber != tt.lineNumber {
 			t.Fatalf("TestLinePosition[%d]: lineNumber expected=%d got=%d",
 				i, tt.lineNumber, s.lineNumber)
@@ -243,3 +251,17 @@ func TestLinePosition(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkForward(b *testing.B) {
+	for _, lines := range []int{1000, 10000} {
+		input := strings.Repeat("let x = 5\n", lines)
+		b.Run(fmt.Sprintf("lines=%d", lines), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s := NewScanner(input)
+				for s.Remaining() > 0 {
+					s.Forward(1)
+				}
+			}
+		})
+	}
+}
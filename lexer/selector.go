@@ -0,0 +1,278 @@
+package lexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Errors that a *SelectorError may wrap, to be checked with errors.Is
+var (
+	ErrSelectorSyntax = errors.New("invalid selector")
+	ErrNoMatch        = errors.New("no node matches")
+	ErrManyMatches    = errors.New("more than one node matches")
+)
+
+// SelectorError is returned by Select and SelectOne.
+type SelectorError struct {
+	Selector string
+	Err      error
+
+	detail string
+}
+
+func (e *SelectorError) Error() string {
+	str := fmt.Sprintf("selector %q: %s", e.Selector, e.Err.Error())
+	if e.detail != "" {
+		str += ": " + e.detail
+	}
+	return str
+}
+
+func (e *SelectorError) Unwrap() error {
+	return e.Err
+}
+
+// selectorStep is a step in a selector, see Select.
+type selectorStep struct {
+	child bool   // only direct children, rather than all descendants
+	group string // the group name to match, or "*" for any group
+	nth   int    // which of the matches to keep, from 1, or 0 for all
+}
+
+// Selector is a compiled selector, see LexNode.Select. Selectors that
+// are used on many trees are best compiled once and reused. A Selector
+// may be used by several goroutines at once.
+type Selector struct {
+	text  string
+	steps []selectorStep
+}
+
+// CompileSelector parses a selector, see LexNode.Select. The error is
+// a *SelectorError if the selector is invalid.
+func CompileSelector(selector string) (*Selector, error) {
+	steps, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{text: selector, steps: steps}, nil
+}
+
+// MustCompileSelector is like CompileSelector, but panics if the
+// selector is invalid. It is meant for selectors that are known when
+// the program is written.
+func MustCompileSelector(selector string) *Selector {
+	sel, err := CompileSelector(selector)
+	if err != nil {
+		panic(err.Error())
+	}
+	return sel
+}
+
+func (sel *Selector) String() string {
+	return sel.text
+}
+
+// Select returns the nodes within n, in the order they occur in the
+// input, that match selector. Only nodes with a group name are taken
+// into account, so the nodes between two groups don't matter. The
+// syntax is like that of CSS selectors:
+//
+//	name        nodes of the given group, anywhere within the context
+//	a b         nodes b anywhere within nodes a
+//	a > b       nodes b within nodes a, without any group in between
+//	> a         nodes a within n, without any group in between
+//	*           nodes of any group
+//	a:nth(k)    the k-th node a within each context, counting from 1
+//
+// For example, "exprCall > args > expression:nth(2)" selects the second
+// argument of a call. A selector that doesn't match anything is no
+// error for Select, see SelectOne.
+func (n *LexNode) Select(selector string) ([]*LexNode, error) {
+	sel, err := CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return sel.Select(n), nil
+}
+
+// SelectOne returns the only node within n that matches selector,
+// see Select. It is an error if no node or more than one node match.
+func (n *LexNode) SelectOne(selector string) (*LexNode, error) {
+	sel, err := CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return sel.SelectOne(n)
+}
+
+// Select returns the nodes within n that match this selector, see
+// LexNode.Select.
+func (sel *Selector) Select(n *LexNode) []*LexNode {
+	contexts := []*LexNode{n}
+	for _, step := range sel.steps {
+		seen := make(map[*LexNode]bool)
+		var matches []*LexNode
+		for _, context := range contexts {
+			var candidates []*LexNode
+			context.selectCandidates(step, &candidates)
+			if step.nth > 0 {
+				if step.nth > len(candidates) {
+					continue
+				}
+				candidates = candidates[step.nth-1 : step.nth]
+			}
+			for _, candidate := range candidates {
+				if !seen[candidate] {
+					seen[candidate] = true
+					matches = append(matches, candidate)
+				}
+			}
+		}
+
+		// Contexts may be nested, so their matches may be as well
+		if len(contexts) > 1 {
+			order := n.preorder()
+			sort.SliceStable(matches, func(i, j int) bool {
+				return order[matches[i]] < order[matches[j]]
+			})
+		}
+		contexts = matches
+	}
+	return contexts
+}
+
+// SelectOne returns the only node within n that matches this selector,
+// see LexNode.SelectOne.
+func (sel *Selector) SelectOne(n *LexNode) (*LexNode, error) {
+	matches := sel.Select(n)
+	switch len(matches) {
+	case 0:
+		return nil, &SelectorError{
+			Selector: sel.text,
+			Err:      ErrNoMatch,
+			detail:   fmt.Sprintf("within %s-%s", n.Start, n.End),
+		}
+	case 1:
+		return matches[0], nil
+	}
+	return nil, &SelectorError{
+		Selector: sel.text,
+		Err:      ErrManyMatches,
+		detail:   fmt.Sprintf("%d nodes within %s-%s", len(matches), n.Start, n.End),
+	}
+}
+
+// selectCandidates appends the nodes within n that match the group
+// name of step, in the order they occur in the input.
+func (n *LexNode) selectCandidates(step selectorStep, candidates *[]*LexNode) {
+	for _, child := range n.Children {
+		if child.GroupName != "" {
+			if step.group == "*" || child.GroupName == step.group {
+				*candidates = append(*candidates, child)
+			}
+			if step.child {
+				continue
+			}
+		}
+		child.selectCandidates(step, candidates)
+	}
+}
+
+// preorder numbers the nodes in this tree in the order they start.
+func (n *LexNode) preorder() map[*LexNode]int {
+	order := make(map[*LexNode]int)
+	var walk func(node *LexNode)
+	walk = func(node *LexNode) {
+		order[node] = len(order)
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(n)
+	return order
+}
+
+func parseSelector(selector string) ([]selectorStep, error) {
+	fail := func(i int, expected string) error {
+		found := "end of selector"
+		if i < len(selector) {
+			found = strconv.Quote(selector[i : i+1])
+		}
+		return &SelectorError{
+			Selector: selector,
+			Err:      ErrSelectorSyntax,
+			detail:   fmt.Sprintf("expected %s at column %d, found %s", expected, i+1, found),
+		}
+	}
+	isNameChar := func(c byte, first bool) bool {
+		return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+			!first && '0' <= c && c <= '9'
+	}
+	skipSpace := func(i int) int {
+		for i < len(selector) && selector[i] == ' ' {
+			i++
+		}
+		return i
+	}
+
+	var steps []selectorStep
+	i := skipSpace(0)
+	for {
+		step := selectorStep{}
+		if i < len(selector) && selector[i] == '>' {
+			step.child = true
+			i = skipSpace(i + 1)
+		}
+
+		// The group name, or any group
+		start := i
+		if i < len(selector) && selector[i] == '*' {
+			i++
+		} else {
+			for i < len(selector) && isNameChar(selector[i], i == start) {
+				i++
+			}
+		}
+		if i == start {
+			return nil, fail(i, "group name")
+		}
+		step.group = selector[start:i]
+
+		// Which of the matches to keep
+		if strings.HasPrefix(selector[i:], ":") {
+			if !strings.HasPrefix(selector[i:], ":nth(") {
+				return nil, fail(i+1, "nth")
+			}
+			i += len(":nth(")
+			start = i
+			for i < len(selector) && '0' <= selector[i] && selector[i] <= '9' {
+				i++
+			}
+			nth, err := strconv.Atoi(selector[start:i])
+			if err != nil || nth < 1 {
+				return nil, fail(start, "positive number")
+			}
+			if i >= len(selector) || selector[i] != ')' {
+				return nil, fail(i, `")"`)
+			}
+			step.nth = nth
+			i++
+		}
+		steps = append(steps, step)
+
+		// Either the end, or a space or > before the next step
+		end := i
+		i = skipSpace(i)
+		if i == len(selector) {
+			break
+		}
+		if i == end && selector[i] != '>' {
+			return nil, fail(i, `" ", ">" or end of selector`)
+		}
+	}
+
+	return steps, nil
+}
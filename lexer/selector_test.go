@@ -0,0 +1,125 @@
+package lexer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+
+	// A call with a call and a declaration as its arguments, so that
+	// groups of the same name are nested within each other
+	var expr Lexer
+	lExpr := Future(&expr, "expr")
+	space := Regex(" *", true)
+	ident := Group("identifier", Regex("[a-z]+", false))
+	call := Group("call", And(ident, Atom("("),
+		Group("args", Interlace(lExpr, And(space, Atom(","), space))), Atom(")")))
+	declare := Group("declare", And(Atom("let "), ident, Atom("="), lExpr))
+	expr = Group("expression", Or(call, declare, ident))
+
+	node, lexErr := And(lExpr, EOF()).Lex(NewScanner("f(g(a, b), let x=y, c)"))
+	if lexErr != nil {
+		t.Fatalf("TestSelect: unexpected error=%s", lexErr.Error())
+	}
+
+	tests := []struct {
+		selector string
+		expected []string
+		err      error
+	}{
+		{"call > identifier", []string{"f", "g"}, nil},
+		{"> expression > call > identifier", []string{"f"}, nil},
+		{"> expression > call > args > expression", []string{"g(a, b)", "let x=y", "c"}, nil},
+		{"args > expression:nth(2)", []string{"b", "let x=y"}, nil},
+		{"args expression:nth(2)", []string{"a", "b"}, nil},
+		{"call > args > expression:nth(2) > call", nil, nil},
+		{"declare > *", []string{"x", "y"}, nil},
+		{"declare identifier", []string{"x", "y"}, nil},
+		{"  args  >expression:nth(3)  ", []string{"c"}, nil},
+		{"missing", nil, nil},
+
+		// Syntax errors
+		{"", nil, ErrSelectorSyntax},
+		{"call >", nil, ErrSelectorSyntax},
+		{"call >> args", nil, ErrSelectorSyntax},
+		{"args:first", nil, ErrSelectorSyntax},
+		{"args:nth(0)", nil, ErrSelectorSyntax},
+		{"args:nth(2", nil, ErrSelectorSyntax},
+		{"args,call", nil, ErrSelectorSyntax},
+	}
+
+	for i, tt := range tests {
+		nodes, err := node.Select(tt.selector)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("TestSelect[%d]: expected error=%v got=%v", i, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestSelect[%d]: unexpected error=%s", i, err.Error())
+		}
+		var values []string
+		for _, n := range nodes {
+			values = append(values, n.Value)
+		}
+		if strings.Join(values, "|") != strings.Join(tt.expected, "|") {
+			t.Fatalf("TestSelect[%d]: expected=%q got=%q", i, tt.expected, values)
+		}
+	}
+
+	// SelectOne fails unless exactly one node matches
+	one := []struct {
+		selector string
+		expected string
+		err      string
+	}{
+		{"declare > identifier:nth(1)", "x", ""},
+		{"missing", "", `selector "missing": no node matches: within 1:1-1:23`},
+		{"call > identifier", "", `selector "call > identifier": more than one node matches: 2 nodes within 1:1-1:23`},
+		{"call >", "", `selector "call >": invalid selector: expected group name at column 7, found end of selector`},
+		{"args:nth(x)", "", `selector "args:nth(x)": invalid selector: expected positive number at column 10, found "x"`},
+	}
+
+	for i, tt := range one {
+		n, err := node.SelectOne(tt.selector)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("TestSelect[one %d]: expected error=%q got=%v", i, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestSelect[one %d]: unexpected error=%s", i, err.Error())
+		}
+		if n.Value != tt.expected {
+			t.Fatalf("TestSelect[one %d]: expected=%q got=%q", i, tt.expected, n.Value)
+		}
+	}
+
+	// Compiled selectors can be reused on any node
+	compiled, err := CompileSelector("> expression > call > identifier")
+	if err != nil {
+		t.Fatalf("TestSelect[compiled]: unexpected error=%s", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		n, err := compiled.SelectOne(node)
+		if err != nil || n.Value != "f" {
+			t.Fatalf("TestSelect[compiled %d]: expected=%q got=%v, %v", i, "f", n, err)
+		}
+	}
+	if compiled.String() != "> expression > call > identifier" {
+		t.Fatalf("TestSelect[compiled]: unexpected String()=%q", compiled.String())
+	}
+	if _, err := CompileSelector("call >"); err == nil || err.Error() != one[3].err {
+		t.Fatalf("TestSelect[compiled]: expected error=%q got=%v", one[3].err, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("TestSelect[compiled]: expected MustCompileSelector to panic")
+		}
+	}()
+	MustCompileSelector("call >")
+}
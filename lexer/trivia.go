@@ -0,0 +1,186 @@
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type BlockCommentLexer struct {
+	open  string
+	close string
+}
+
+// BlockComment matches a comment from open up to the matching close.
+// Comments may be nested, so "/* a /* b */ c */" is a single comment.
+// Once a comment was opened, failing to find its end is an error,
+// rather than a reason to try anything else.
+func BlockComment(open string, close string) Lexer {
+	return &BlockCommentLexer{
+		open:  open,
+		close: close,
+	}
+}
+
+func (p *BlockCommentLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	if s.ConsumeString(p.open) != p.open {
+		s.expect(strconv.Quote(p.open))
+		return nil, err(s, fmt.Sprintf("Expected comment: %s", p.open), p)
+	}
+
+	depth := 1
+	for depth > 0 {
+		switch {
+		case s.MatchString(p.close) > 0:
+			s.Forward(len(p.close))
+			depth--
+		case s.MatchString(p.open) > 0:
+			s.Forward(len(p.open))
+			depth++
+		case s.atEnd():
+			e := &LexError{
+				err:       "Unterminated comment",
+				position:  start,
+				fixed:     true,
+				committed: true,
+			}
+			e.Trace(s, p)
+			return nil, e
+		default:
+			_, size := s.peekRune()
+			s.Forward(size)
+		}
+	}
+
+	return &LexNode{
+		Name:  "comment",
+		Value: s.slice(start.Offset, s.index),
+		Start: start,
+		End:   s.Position(),
+	}, nil
+}
+
+func (p *BlockCommentLexer) ToString() string {
+	return fmt.Sprintf("BLOCKCOMMENT(%s, %s)",
+		strconv.Quote(p.open), strconv.Quote(p.close))
+}
+
+type LineCommentLexer struct {
+	prefix string
+}
+
+// LineComment matches a comment from prefix up to the end of the
+// line, excluding the newline itself.
+func LineComment(prefix string) Lexer {
+	return &LineCommentLexer{prefix: prefix}
+}
+
+func (p *LineCommentLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	if s.ConsumeString(p.prefix) != p.prefix {
+		s.expect(strconv.Quote(p.prefix))
+		return nil, err(s, fmt.Sprintf("Expected comment: %s", p.prefix), p)
+	}
+
+	for !s.atEnd() && s.MatchString("\n") == 0 {
+		_, size := s.peekRune()
+		s.Forward(size)
+	}
+
+	return &LexNode{
+		Name:  "comment",
+		Value: s.slice(start.Offset, s.index),
+		Start: start,
+		End:   s.Position(),
+	}, nil
+}
+
+func (p *LineCommentLexer) ToString() string {
+	return fmt.Sprintf("LINECOMMENT(%s)", strconv.Quote(p.prefix))
+}
+
+type TriviaLexer struct {
+	space      Lexer
+	comments   []Lexer
+	allowEmpty bool
+
+	alternatives []Lexer
+}
+
+// Trivia matches any sequence of whitespace, as matched by space,
+// and comments. The comments are kept as "comment" nodes in the
+// tree, see LexNode.Comments. Trivia is optional wherever it may be
+// empty, so failing to find more of it is never reported in errors.
+func Trivia(space Lexer, allowEmpty bool, comments ...Lexer) Lexer {
+	return &TriviaLexer{
+		space:      space,
+		comments:   comments,
+		allowEmpty: allowEmpty,
+
+		alternatives: append([]Lexer{space}, comments...),
+	}
+}
+
+func (p *TriviaLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	start := s.Position()
+	failures := s.saveFailures()
+
+	var nodes []*LexNode
+	for {
+		node, lexErr := p.lexOne(s)
+		if lexErr != nil {
+			s.restoreFailures(failures)
+			lexErr.Trace(s, p)
+			return nil, lexErr
+		}
+		if node == nil {
+			break
+		}
+		nodes = append(nodes, node)
+	}
+
+	s.restoreFailures(failures)
+	if len(nodes) == 0 && !p.allowEmpty {
+		s.expect("whitespace")
+		return nil, err(s, "Expected whitespace", p)
+	}
+
+	value, ignored := s.value(start, nodes)
+	return &LexNode{
+		Name:     "trivia",
+		Value:    value,
+		ignored:  ignored,
+		Children: nodes,
+		Start:    start,
+		End:      s.Position(),
+	}, nil
+}
+
+// lexOne lexes a single piece of whitespace or comment, if any. Only
+// committed errors, such as unterminated comments, are returned.
+func (p *TriviaLexer) lexOne(s *Scanner) (*LexNode, *LexError) {
+	for _, lex := range p.alternatives {
+		start := s.index
+		s.Push()
+		node, lexErr := lex.Lex(s)
+		if lexErr == nil && s.index > start {
+			s.Discard()
+			return node, nil
+		}
+		if lexErr != nil && (lexErr.committed || s.Committed()) {
+			s.Discard()
+			lexErr.committed = true
+			return nil, lexErr
+		}
+		s.Pop()
+	}
+	return nil, nil
+}
+
+func (p *TriviaLexer) ToString() string {
+	str := "TRIVIA(" + p.space.ToString()
+	for _, comment := range p.comments {
+		str += ", " + comment.ToString()
+	}
+	return str + ")"
+}
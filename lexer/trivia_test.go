@@ -0,0 +1,57 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTriviaLexer(t *testing.T) {
+
+	space := Regex("[\n\t ]+", false)
+	comments := []Lexer{BlockComment("/*", "*/"), LineComment("//")}
+	anySpace := Trivia(space, true, comments...)
+	someSpace := Trivia(space, false, comments...)
+	word := Regex("[a-z]+", false)
+
+	tests := []struct {
+		input    string
+		lex      Lexer
+		comments []string
+		err      string
+	}{
+		{"x", And(word, anySpace, EOF()), nil, ""},
+		{"x y", And(word, someSpace, word, EOF()), nil, ""},
+		{"x/**/y", And(word, someSpace, word, EOF()), []string{"/**/"}, ""},
+		{"x // a\n  /* b /* c */ d */ y", And(word, anySpace, word, EOF()),
+			[]string{"// a", "/* b /* c */ d */"}, ""},
+		{"x /* ä // */ // ö", And(word, anySpace, EOF()), []string{"/* ä // */", "// ö"}, ""},
+		{"xy", And(word, someSpace, word), nil, "1:3: expected whitespace"},
+		{"x /* a /* b */", And(word, anySpace, EOF()), nil, "1:3: Unterminated comment"},
+		{"x\n\n  /* a /* b */ */ /* c", Or(And(word, anySpace, EOF()), word), nil, "3:19: Unterminated comment"},
+		{"x /* c */ + /* d */ y", Operators(word, anySpace, InfixOp(1, LeftAssoc, Atom("+"))),
+			[]string{"/* c */", "/* d */"}, ""},
+	}
+
+	for i, tt := range tests {
+		node, err := tt.lex.Lex(NewScanner(tt.input))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("TestTriviaLexer[%d]: expected error=%q got=%v",
+					i, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestTriviaLexer[%d]: unexpected error=%s", i, err.Error())
+		}
+
+		var comments []string
+		for _, comment := range node.Comments() {
+			comments = append(comments, comment.Value)
+		}
+		if strings.Join(comments, "|") != strings.Join(tt.comments, "|") {
+			t.Fatalf("TestTriviaLexer[%d]: expected comments=%q got=%q",
+				i, tt.comments, comments)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package lexer
+
+// Parser is a lexer that builds a value of type T out of what it
+// matches, so that a syntax tree can be built while lexing, rather
+// than by searching the LexNode tree for groups afterwards. The value
+// is kept in the Extra field of a "value" node, so a Parser can be
+// used wherever a Lexer can, and is memoized like any other lexer.
+// Parsers are built from lexers with Build or Token, and combined
+// with Map, Seq2, Seq3, Left, Right, Choice and Many.
+type Parser[T any] struct {
+	lexer Lexer
+}
+
+func (p *Parser[T]) Lex(s *Scanner) (*LexNode, *LexError) {
+	return p.lexer.Lex(s)
+}
+
+// Parse lexes the input at the current position, and returns the
+// value that was built from it.
+func (p *Parser[T]) Parse(s *Scanner) (T, *LexError) {
+	node, lexErr := p.lexer.Lex(s)
+	if lexErr != nil {
+		var zero T
+		return zero, lexErr
+	}
+	return value[T](node), nil
+}
+
+func (p *Parser[T]) ToString() string {
+	return p.lexer.ToString()
+}
+
+func (p *Parser[T]) wrapped() Lexer {
+	return p.lexer
+}
+
+// wrapper is implemented by lexers that match the same as the lexer
+// they wrap, so that grammars can be walked through them.
+type wrapper interface {
+	wrapped() Lexer
+}
+
+// valueLexer puts the value built from what child matched into a
+// node of its own.
+type valueLexer struct {
+	child Lexer
+	build func(node *LexNode) interface{}
+}
+
+func (p *valueLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	node, lexErr := p.child.Lex(s)
+	if lexErr != nil {
+		lexErr.Trace(s, p)
+		return nil, lexErr
+	}
+	return &LexNode{
+		Name:     "value",
+		Value:    node.Value,
+		ignored:  node.ignored,
+		Children: []*LexNode{node},
+		Extra:    p.build(node),
+		Start:    node.Start,
+		End:      node.End,
+	}, nil
+}
+
+func (p *valueLexer) ToString() string {
+	return p.child.ToString()
+}
+
+func (p *valueLexer) wrapped() Lexer {
+	return p.child
+}
+
+func newParser[T any](child Lexer, build func(node *LexNode) T) *Parser[T] {
+	return &Parser[T]{lexer: &valueLexer{
+		child: child,
+		build: func(node *LexNode) interface{} { return build(node) },
+	}}
+}
+
+// value returns the value built by the parser that returned node,
+// which may be a nil interface.
+func value[T any](node *LexNode) T {
+	v, _ := node.Extra.(T)
+	return v
+}
+
+// Build turns a lexer into a parser, which builds its value from the
+// node that the lexer returns.
+func Build[T any](l Lexer, build func(node *LexNode) T) *Parser[T] {
+	return newParser(l, build)
+}
+
+// Token turns a lexer into a parser for the text it matches.
+func Token(l Lexer) *Parser[string] {
+	return newParser(l, func(node *LexNode) string {
+		return node.Value
+	})
+}
+
+// Map builds a value out of the value of p.
+func Map[A, B any](p *Parser[A], f func(A) B) *Parser[B] {
+	return newParser(p.lexer, func(node *LexNode) B {
+		return f(value[A](node))
+	})
+}
+
+// Seq2 matches a and then b, and builds a value out of both of theirs.
+func Seq2[A, B, R any](a *Parser[A], b *Parser[B], f func(A, B) R) *Parser[R] {
+	return newParser(And(a.lexer, b.lexer), func(node *LexNode) R {
+		return f(value[A](node.Children[0]), value[B](node.Children[1]))
+	})
+}
+
+// Seq3 matches a, b and then c, and builds a value out of their values.
+func Seq3[A, B, C, R any](a *Parser[A], b *Parser[B], c *Parser[C], f func(A, B, C) R) *Parser[R] {
+	return newParser(And(a.lexer, b.lexer, c.lexer), func(node *LexNode) R {
+		return f(value[A](node.Children[0]), value[B](node.Children[1]), value[C](node.Children[2]))
+	})
+}
+
+// Left matches p and then after, and keeps the value of p.
+func Left[T any](p *Parser[T], after Lexer) *Parser[T] {
+	return newParser(And(p.lexer, after), func(node *LexNode) T {
+		return value[T](node.Children[0])
+	})
+}
+
+// Right matches before and then p, and keeps the value of p.
+func Right[T any](before Lexer, p *Parser[T]) *Parser[T] {
+	return newParser(And(before, p.lexer), func(node *LexNode) T {
+		return value[T](node.Children[1])
+	})
+}
+
+// Choice returns the value of the first alternative that matches.
+func Choice[T any](alternatives ...*Parser[T]) *Parser[T] {
+	lexers := make([]Lexer, len(alternatives))
+	for i, alternative := range alternatives {
+		lexers[i] = alternative.lexer
+	}
+	return newParser(Or(lexers...), func(node *LexNode) T {
+		return value[T](node.Children[0])
+	})
+}
+
+// Many matches p at least min and at most max times, like Repeat, and
+// returns all of its values.
+func Many[T any](p *Parser[T], min int, max int) *Parser[[]T] {
+	return newParser(Repeat(p.lexer, min, max), func(node *LexNode) []T {
+		values := make([]T, len(node.Children))
+		for i, child := range node.Children {
+			values[i] = value[T](child)
+		}
+		return values
+	})
+}
+
+// FutureParser refers to a parser that is defined later on, like
+// Future does for lexers, so that parsers can be recursive.
+func FutureParser[T any](pointer **Parser[T], name string) *Parser[T] {
+	var ref Lexer = &parserRef[T]{pointer: pointer}
+	return &Parser[T]{lexer: Future(&ref, name)}
+}
+
+// parserRef applies the parser that pointer points to.
+type parserRef[T any] struct {
+	pointer **Parser[T]
+}
+
+func (p *parserRef[T]) Lex(s *Scanner) (*LexNode, *LexError) {
+	return (*p.pointer).Lex(s)
+}
+
+func (p *parserRef[T]) ToString() string {
+	return (*p.pointer).ToString()
+}
+
+func (p *parserRef[T]) wrapped() Lexer {
+	if *p.pointer == nil {
+		return nil
+	}
+	return *p.pointer
+}
@@ -0,0 +1,130 @@
+package lexer
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestTypedParser(t *testing.T) {
+
+	type operation struct {
+		operator string
+		operand  int
+	}
+	apply := func(left int, ops []operation) int {
+		for _, op := range ops {
+			switch op.operator {
+			case "+":
+				left += op.operand
+			case "-":
+				left -= op.operand
+			case "*":
+				left *= op.operand
+			case "/":
+				left /= op.operand
+			}
+		}
+		return left
+	}
+	operationOf := func(operator string, operand int) operation {
+		return operation{operator, operand}
+	}
+
+	// expr = term (("+" | "-") term)*, and so on
+	var expr *Parser[int]
+	lExpr := FutureParser(&expr, "expr")
+	number := Map(Token(Regex("[0-9]+", false)), func(digits string) int {
+		n, _ := strconv.Atoi(digits)
+		return n
+	})
+	factor := Choice(number, Right(Atom("("), Left(lExpr, Atom(")"))))
+	term := Seq2(factor, Many(Seq2(Token(Or(Atom("*"), Atom("/"))), factor, operationOf), 0, -1), apply)
+	expr = Seq2(term, Many(Seq2(Token(Or(Atom("+"), Atom("-"))), term, operationOf), 0, -1), apply)
+	calc := Left(lExpr, EOF())
+
+	// sum = sum "-" number | number, which is left recursive
+	var sum *Parser[int]
+	lSum := FutureParser(&sum, "sum")
+	sum = Choice(
+		Seq3(lSum, Token(Atom("-")), number, func(left int, _ string, right int) int {
+			return left - right
+		}),
+		number,
+	)
+
+	// Values can be built from the nodes as well, e.g. for positions
+	position := Build(Regex("[a-z]+", false), func(node *LexNode) int {
+		return node.Start.Offset
+	})
+	words := Left(Many(Right(Regex(" *", true), position), 0, -1), EOF())
+
+	tests := []struct {
+		parser   *Parser[int]
+		input    string
+		expected int
+		err      string
+	}{
+		{calc, "1+2*3", 7, ""},
+		{calc, "(1+2)*3", 9, ""},
+		{calc, "8/2/2", 2, ""},
+		{calc, "2*(3+4)-5", 9, ""},
+		{calc, "1+", 0, `1:3: expected one of /[0-9]+/, "("`},
+		{calc, "(1", 0, `1:3: expected one of "*", "/", "+", "-", ")"`},
+		{Left(lSum, EOF()), "10-2-3", 5, ""},
+		{Map(words, func(offsets []int) int { return offsets[2] }), "ab cd  ef", 7, ""},
+	}
+
+	for _, memo := range []bool{false, true} {
+		for i, tt := range tests {
+			s := NewScanner(tt.input)
+			if memo {
+				s.EnableMemo()
+			}
+			result, err := tt.parser.Parse(s)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("TestTypedParser[%d]: expected error=%q got=%v",
+						i, tt.err, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("TestTypedParser[%d]: unexpected error=%s", i, err.Error())
+			}
+			if result != tt.expected {
+				t.Fatalf("TestTypedParser[%d]: expected=%d got=%d", i, tt.expected, result)
+			}
+		}
+	}
+
+	// Parsers are lexers too, and can be exported and analyzed
+	if EBNF(calc) != "grammar ::= expr <end of input>\n\n"+
+		"expr ::= (/[0-9]+/ | \"(\" expr \")\") ((\"*\" | \"/\") (/[0-9]+/ | \"(\" expr \")\"))* "+
+		"((\"+\" | \"-\") (/[0-9]+/ | \"(\" expr \")\") ((\"*\" | \"/\") (/[0-9]+/ | \"(\" expr \")\"))*)*\n" {
+		t.Fatalf("TestTypedParser: unexpected EBNF=%q", EBNF(calc))
+	}
+	if problems := Analyze(lSum); len(problems) != 1 || problems[0].Kind != LeftRecursion {
+		t.Fatalf("TestTypedParser: expected left recursion, got=%v", problems)
+	}
+}
+
+func TestTypedParserNil(t *testing.T) {
+	type expr interface{}
+	none := Map(Token(Atom("x")), func(string) expr { return nil })
+	pair := Seq2(none, none, func(a, b expr) []expr { return []expr{a, b} })
+
+	v, err := none.Parse(NewScanner("x"))
+	if err != nil {
+		t.Fatalf("TestTypedParserNil: unexpected error=%s", err.Error())
+	}
+	if v != nil {
+		t.Fatalf("TestTypedParserNil: expected nil got=%v", v)
+	}
+	values, err := pair.Parse(NewScanner("xx"))
+	if err != nil {
+		t.Fatalf("TestTypedParserNil: unexpected error=%s", err.Error())
+	}
+	if len(values) != 2 || values[0] != nil || values[1] != nil {
+		t.Fatalf("TestTypedParserNil: expected [nil nil] got=%v", values)
+	}
+}
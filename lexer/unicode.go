@@ -0,0 +1,169 @@
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ColumnUnit determines how the columns of a Position are counted.
+type ColumnUnit int
+
+const (
+	// ColumnRunes counts every Unicode code point as one column.
+	ColumnRunes ColumnUnit = iota
+
+	// ColumnUTF16 counts UTF-16 code units, as most editors and
+	// the Language Server Protocol do. Code points outside the
+	// Basic Multilingual Plane take up two columns.
+	ColumnUTF16
+
+	// ColumnBytes counts bytes of UTF-8 encoded input.
+	ColumnBytes
+)
+
+// count returns the number of columns taken up by str.
+func (u ColumnUnit) count(str string) int {
+	switch u {
+	case ColumnUTF16:
+		count := 0
+		for _, r := range str {
+			count += utf16Len(r)
+		}
+		return count
+	case ColumnBytes:
+		return len(str)
+	}
+	return utf8.RuneCountInString(str)
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 && r <= unicode.MaxRune {
+		return 2
+	}
+	return 1
+}
+
+// SetColumnUnit changes how the columns of positions are counted
+// by this scanner. By default, columns are counted in runes.
+func (s *Scanner) SetColumnUnit(unit ColumnUnit) {
+	s.unit = unit
+	s.columnCache = scannerState{}
+	s.updateLinePosition()
+}
+
+// Validate checks that the input is valid UTF-8, returning an error
+// pointing at the first invalid byte if it isn't. When reading from
+// a reader, only the input that was read so far is checked.
+func (s *Scanner) Validate() *LexError {
+	for s.invalid == nil && s.validated < s.end() {
+		rest := s.slice(s.validated, s.end())
+		r, size := utf8.DecodeRuneInString(rest)
+		if r == utf8.RuneError && size <= 1 {
+
+			// The rest of this rune may not have been read yet
+			if !s.eof && s.reader != nil && !utf8.FullRuneInString(rest) {
+				break
+			}
+
+			s.invalid = &LexError{
+				err:      "Invalid UTF-8 encoding",
+				position: s.positionAt(s.validated),
+			}
+			break
+		}
+		s.validated += size
+	}
+	return s.invalid
+}
+
+// peekRune returns the rune at the current position, and its size
+// in bytes. Invalid UTF-8 is returned as utf8.RuneError, size 1.
+func (s *Scanner) peekRune() (rune, int) {
+	s.fill(utf8.UTFMax)
+	return utf8.DecodeRuneInString(s.slice(s.index, s.end()))
+}
+
+type RuneLexer struct {
+	r rune
+}
+
+// Rune matches a single, specific rune.
+func Rune(r rune) Lexer {
+	return &RuneLexer{r: r}
+}
+
+func (p *RuneLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	return lexRune(s, p, strconv.QuoteRune(p.r), func(r rune) bool {
+		return r == p.r
+	})
+}
+
+func (p *RuneLexer) ToString() string {
+	return fmt.Sprintf("RUNE(%s)", strconv.QuoteRune(p.r))
+}
+
+type RuneClassLexer struct {
+	tables []*unicode.RangeTable
+}
+
+// RuneClass matches a single rune that is in any of the given
+// Unicode classes, such as unicode.Letter or unicode.Digit.
+func RuneClass(tables ...*unicode.RangeTable) Lexer {
+	return &RuneClassLexer{tables: tables}
+}
+
+func (p *RuneClassLexer) Lex(s *Scanner) (*LexNode, *LexError) {
+	expected := "class(" + strings.Join(p.names(), ", ") + ")"
+	return lexRune(s, p, expected, func(r rune) bool {
+		return r != utf8.RuneError && unicode.IsOneOf(p.tables, r)
+	})
+}
+
+// names returns the names of the classes of this lexer, as
+// used for the tables in the unicode package.
+func (p *RuneClassLexer) names() []string {
+	var names []string
+	for _, table := range p.tables {
+		names = append(names, tableName(table))
+	}
+	return names
+}
+
+func (p *RuneClassLexer) ToString() string {
+	return fmt.Sprintf("RUNECLASS(%s)", strings.Join(p.names(), ", "))
+}
+
+func tableName(table *unicode.RangeTable) string {
+	for _, tables := range []map[string]*unicode.RangeTable{
+		unicode.Categories,
+		unicode.Scripts,
+		unicode.Properties,
+	} {
+		for name, candidate := range tables {
+			if candidate == table {
+				return name
+			}
+		}
+	}
+	return "custom"
+}
+
+func lexRune(s *Scanner, lex Lexer, expected string, match func(rune) bool) (*LexNode, *LexError) {
+	start := s.Position()
+
+	r, size := s.peekRune()
+	if size == 0 || !match(r) {
+		s.expect(expected)
+		return nil, err(s, fmt.Sprintf("Expected rune: %s", expected), lex)
+	}
+
+	return &LexNode{
+		Name:  "rune",
+		Value: s.Forward(size),
+		Start: start,
+		End:   s.Position(),
+	}, nil
+}
@@ -0,0 +1,110 @@
+package lexer
+
+import (
+	"testing"
+	"unicode"
+)
+
+func TestColumnUnit(t *testing.T) {
+	tests := []struct {
+		input  string
+		index  int
+		unit   ColumnUnit
+		line   int
+		column int
+	}{
+		{"héllo", 3, ColumnRunes, 1, 3},
+		{"héllo", 3, ColumnBytes, 1, 4},
+		{"héllo", 3, ColumnUTF16, 1, 3},
+		{"a😀b", 5, ColumnRunes, 1, 3},
+		{"a😀b", 5, ColumnUTF16, 1, 4},
+		{"😀\n😀x", 9, ColumnRunes, 2, 2},
+		{"😀\n😀x", 9, ColumnUTF16, 2, 3},
+		{"a\xffb", 2, ColumnRunes, 1, 3},
+	}
+
+	for i, tt := range tests {
+		s := NewScanner(tt.input)
+		s.SetColumnUnit(tt.unit)
+		s.Forward(tt.index)
+
+		position := s.Position()
+		if position.Line != tt.line || position.Column != tt.column {
+			t.Fatalf("TestColumnUnit[%d]: expected=%d:%d got=%s",
+				i, tt.line, tt.column, position)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"valid ünïcode", ""},
+		{"a\nb\xffc", "2:2: Invalid UTF-8 encoding"},
+		{"ab\xe2\x82", "1:3: Invalid UTF-8 encoding"},
+	}
+
+	for i, tt := range tests {
+		s := NewScanner(tt.input)
+		err := s.Validate()
+		if tt.expected == "" {
+			if err != nil {
+				t.Fatalf("TestValidate[%d]: unexpected error=%s",
+					i, err.Error())
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Fatalf("TestValidate[%d]: expected=%s got=%v",
+				i, tt.expected, err)
+		}
+	}
+}
+
+func TestRuneLexer(t *testing.T) {
+	tests := []struct {
+		input    string
+		lex      Lexer
+		expected string
+		fail     bool
+	}{
+		{"ñu", Rune('ñ'), "ñ", false},
+		{"nu", Rune('ñ'), "", true},
+		{"日本語!", Repeat(RuneClass(unicode.Han), 1, -1), "日本語", false},
+		{"Ωmega1", Repeat(RuneClass(unicode.Letter), 1, -1), "Ωmega", false},
+		{"x1", And(RuneClass(unicode.Letter), RuneClass(unicode.Letter, unicode.Digit)), "x1", false},
+		{"\xff", RuneClass(unicode.Letter, unicode.Symbol), "", true},
+		{"", Rune('a'), "", true},
+	}
+
+	for i, tt := range tests {
+		s := NewScanner(tt.input)
+		node, err := tt.lex.Lex(s)
+		if tt.fail {
+			if err == nil {
+				t.Fatalf("TestRuneLexer[%d]: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestRuneLexer[%d]: unexpected error=%s", i, err.Error())
+		}
+		if node.Value != tt.expected {
+			t.Fatalf("TestRuneLexer[%d]: expected=%s got=%s",
+				i, tt.expected, node.Value)
+		}
+	}
+}
+
+func TestRuneClassError(t *testing.T) {
+	s := NewScanner("1")
+	_, err := RuneClass(unicode.Lu, unicode.Nd).Lex(NewScanner("a"))
+	if err == nil || err.Error() != "1:1: expected class(Lu, Nd)" {
+		t.Fatalf("TestRuneClassError: got=%v", err)
+	}
+	if _, err := RuneClass(unicode.Lu, unicode.Nd).Lex(s); err != nil {
+		t.Fatalf("TestRuneClassError: unexpected error=%s", err.Error())
+	}
+}
@@ -0,0 +1,109 @@
+package lexer
+
+// children returns the lexers that l is built from, in the order
+// they are applied. The child of a Future is the lexer it points
+// to, if that was defined.
+func children(l Lexer) []Lexer {
+	switch l := l.(type) {
+	case wrapper:
+		if l.wrapped() == nil {
+			return nil
+		}
+		return []Lexer{l.wrapped()}
+	case *AndLexer:
+		return l.children
+	case *OrLexer:
+		return l.children
+	case *RepeatLexer:
+		return []Lexer{l.child}
+	case *GroupLexer:
+		return []Lexer{l.child}
+	case *IgnoreLexer:
+		return []Lexer{l.child}
+	case *FutureLexer:
+		if *l.pointer == nil {
+			return nil
+		}
+		return []Lexer{*l.pointer}
+	case *InterlaceLexer:
+		return []Lexer{l.outer, l.inner}
+	case *RecoverLexer:
+		return []Lexer{l.child, l.sync}
+	case *PeekLexer:
+		return []Lexer{l.child}
+	case *NotLexer:
+		return []Lexer{l.child}
+	case *KeywordLexer:
+		return []Lexer{l.lexer}
+	case *ReservedLexer:
+		return []Lexer{l.child}
+	case *TriviaLexer:
+		return l.alternatives
+	case *OperatorsLexer:
+		lexers := []Lexer{l.operand}
+		if l.space != nil {
+			lexers = append(lexers, l.space)
+		}
+		for _, op := range l.table {
+			lexers = append(lexers, op.Token)
+		}
+		return lexers
+	}
+	return nil
+}
+
+// grammarRule is a named part of a grammar, see grammarRules.
+type grammarRule struct {
+	name   string
+	body   Lexer
+	future *FutureLexer // nil for the root, unless that's a Future
+	parent int          // the rule it was first reached from, or -1
+}
+
+// grammarRules splits the grammar rooted at root into rules: one
+// for every Future that can be reached from root, named after that
+// Future, breadth first. Unless root is a Future itself, it comes
+// first as rule "grammar". The body of a Future that was never
+// defined is nil.
+func grammarRules(root Lexer) []grammarRule {
+	var rules []grammarRule
+	seen := make(map[Lexer]bool)
+
+	// Walk the body of each rule up to the Futures in it, which are
+	// rules of their own, to be walked in turn.
+	queue := []Lexer{root}
+	parents := []int{-1}
+	var walk func(l Lexer)
+	walk = func(l Lexer) {
+		if seen[l] {
+			return
+		}
+		seen[l] = true
+		if _, ok := l.(*FutureLexer); ok {
+			queue = append(queue, l)
+			parents = append(parents, len(rules)-1)
+			return
+		}
+		for _, child := range children(l) {
+			walk(child)
+		}
+	}
+
+	for len(rules) < len(queue) {
+		l := queue[len(rules)]
+
+		rule := grammarRule{name: "grammar", body: l, parent: parents[len(rules)]}
+		if future, ok := l.(*FutureLexer); ok {
+			rule.name = future.name
+			rule.body = *future.pointer
+			rule.future = future
+		}
+		rules = append(rules, rule)
+
+		seen[l] = true
+		for _, child := range children(rule.body) {
+			walk(child)
+		}
+	}
+	return rules
+}
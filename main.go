@@ -2,18 +2,46 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sol/lexer"
 	"sol/parser"
 	"sol/runtime"
 )
 
 func main() {
 
-	reader := bufio.NewReader(os.Stdin)
+	if len(os.Args) > 1 && os.Args[1] == "grammar" {
+		os.Exit(grammar(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ast" {
+		os.Exit(printAST(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "coverage" {
+		os.Exit(coverage(os.Args[2:]))
+	}
+
 	parser := parser.NewParser()
 	env := runtime.NewEnv()
 
+	// Run programs piped into stdin as a whole
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		prog, err := parser.ParseReader(bufio.NewReader(os.Stdin))
+		if err != nil {
+			fmt.Printf("error: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		result := env.Evaluate(prog)
+		fmt.Printf("%s\n", result.ToString())
+		return
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
 	fmt.Println("sol 0.0.1-alpha")
 	for {
 		fmt.Printf("> ")
@@ -25,7 +53,7 @@ func main() {
 
 		prog, err := parser.Parse(input)
 		if err != nil {
-			fmt.Printf("error: \"%s\"\n", input)
+			fmt.Printf("error: %s\n", err.Error())
 			continue
 		}
 
@@ -34,3 +62,140 @@ func main() {
 	}
 
 }
+
+// grammar prints the grammar of sol as EBNF, or with -svg, writes a
+// railroad diagram for each rule into the given directory.
+func grammar(args []string) int {
+	flags := flag.NewFlagSet("sol grammar", flag.ContinueOnError)
+	svgDir := flags.String("svg", "", "write railroad diagrams to this directory")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *svgDir == "" {
+		fmt.Print(lexer.EBNF(parser.Grammar()))
+		return 0
+	}
+
+	if err := os.MkdirAll(*svgDir, 0755); err != nil {
+		fmt.Printf("error: %s\n", err.Error())
+		return 1
+	}
+	for _, diagram := range lexer.Railroad(parser.Grammar()) {
+		path := filepath.Join(*svgDir, diagram.Rule+".svg")
+		if err := os.WriteFile(path, []byte(diagram.SVG), 0644); err != nil {
+			fmt.Printf("error: %s\n", err.Error())
+			return 1
+		}
+	}
+	return 0
+}
+
+// printAST parses a file and prints its syntax tree, or with -json,
+// the tree as JSON, including the position of every node.
+func printAST(args []string) int {
+	flags := flag.NewFlagSet("sol ast", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the tree as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: sol ast [-json] file.sol")
+		flags.PrintDefaults()
+	}
+
+	// Flags may come after the file as well
+	var files []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return 2
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		files = append(files, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(files) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	file, err := os.Open(files[0])
+	if err != nil {
+		fmt.Printf("error: %s\n", err.Error())
+		return 1
+	}
+	defer file.Close()
+
+	// Programs with statements that could not be parsed are still
+	// printed, with a bad statement in their place
+	status := 0
+	prog, err := parser.NewParser().ParseReader(bufio.NewReader(file))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		status = 1
+	}
+	if prog == nil {
+		return status
+	}
+
+	if !*asJSON {
+		fmt.Print(prog.ToString())
+		return status
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(prog); err != nil {
+		fmt.Printf("error: %s\n", err.Error())
+		return 1
+	}
+	return status
+}
+
+// coverage parses the given files and prints which rules of the
+// grammar they matched, and which alternatives they never took, or
+// with -html, writes the report as a page to the given file.
+func coverage(args []string) int {
+	flags := flag.NewFlagSet("sol coverage", flag.ContinueOnError)
+	htmlFile := flags.String("html", "", "write the report as HTML to this file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: sol coverage [-html file] file.sol...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	// Files that don't parse are reported, but still count
+	status := 0
+	p := parser.NewParser()
+	c := lexer.NewCoverage()
+	p.EnableCoverage(c)
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Printf("error: %s\n", err.Error())
+			return 1
+		}
+		_, err = p.ParseReader(bufio.NewReader(file))
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", path, err.Error())
+			status = 1
+		}
+	}
+
+	report := c.Report(parser.Grammar())
+	if *htmlFile == "" {
+		fmt.Print(report.Text())
+		return status
+	}
+	if err := os.WriteFile(*htmlFile, []byte(report.HTML()), 0644); err != nil {
+		fmt.Printf("error: %s\n", err.Error())
+		return 1
+	}
+	return status
+}
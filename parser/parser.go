@@ -1,11 +1,18 @@
 package parser
 
 import (
+	_ "embed"
 	"fmt"
+	"io"
+	"math/rand"
 	"sol/ast"
 	"sol/lexer"
+	"sync"
 )
 
+//go:embed sol.peg
+var grammar string
+
 type ParseError struct {
 	err   string
 	stack []string
@@ -29,8 +36,23 @@ func (e *ParseError) Error() string {
 }
 
 func (e *ParseError) Trace(n *lexer.LexNode, parsing string) {
-	e.stack = append(e.stack, fmt.Sprintf("at %d:%d parsing %s",
-		n.LineNumber, n.LineIndex, parsing))
+	e.stack = append(e.stack, fmt.Sprintf("at %s-%s parsing %s",
+		n.Start, n.End, parsing))
+}
+
+// ErrorList is returned by Parse when some statements could not be
+// parsed. The program returned alongside it is still usable, and
+// has an ast.BadStatement in place of each of these statements.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0].Error(), len(l)-1)
 }
 
 type ParserScope struct {
@@ -52,282 +74,181 @@ func (s *ParserScope) IsDeclared(name string) bool {
 	return false
 }
 
-type Parser struct{}
+// Parser parses sol programs. The grammar is built when the parser
+// is created, and is never changed while parsing, as all state is kept
+// in the scanner of each call. A Parser may thus be used by several
+// goroutines at once.
+type Parser struct {
+	program  lexer.Lexer
+	coverage *lexer.Coverage
+
+	// The rules ParseTree and Reparse lex programs with, one
+	// statement at a time, unless sol.peg lacks any of them
+	statement lexer.Lexer
+	separator lexer.Lexer
+	space     lexer.Lexer
+	end       lexer.Lexer
+	err       error
+}
+
+// program is the grammar shared by all parsers, compiled on first use.
+var program struct {
+	once      sync.Once
+	rules     map[string]lexer.Lexer
+	statement lexer.Lexer
+	separator lexer.Lexer
+	space     lexer.Lexer
+	end       lexer.Lexer
+	err       error
+}
 
 func NewParser() *Parser {
-	return &Parser{}
+	compileProgram()
+	return &Parser{
+		program:   program.rules["program"],
+		statement: program.statement,
+		separator: program.separator,
+		space:     program.space,
+		end:       program.end,
+		err:       program.err,
+	}
+}
+
+// EnableCoverage makes the parser count which rules of the grammar
+// each parse matched in c, see lexer.Coverage. Report c against
+// Grammar(). It must be called before the parser is shared between
+// goroutines.
+func (p *Parser) EnableCoverage(c *lexer.Coverage) {
+	p.coverage = c
 }
 
 func (p *Parser) Parse(input string) (*ast.Program, error) {
+	return p.parse(lexer.NewScanner(input))
+}
 
-	s := lexer.NewScanner(input)
-
-	// Basic syntax
-	lSomeSpace := lexer.Regex("[\n\t\r ]+", false)
-	lAnySpace := lexer.Regex("[\n\t\r ]*", true)
-
-	lComma := lexer.Atom(",")
-	lParenOpen := lexer.Atom("(")
-	lParenClose := lexer.Atom(")")
-	lBraceOpen := lexer.Atom("{")
-	lBraceClose := lexer.Atom("}")
-
-	// Literals
-	lIdent := lexer.Group("identifier", lexer.Regex("[a-zA-Z]+", false))
-	lInteger := lexer.Group("integer", lexer.Regex("[0-9]+", false))
-	lString := lexer.Regex(`"((?:\\\\|\\"|[^"])+)"`, false)
-	lFalse := lexer.Atom("false")
-	lTrue := lexer.Atom("true")
-	lNil := lexer.Atom("nil")
-
-	// ???
-	lParamSep := lexer.And(
-		lAnySpace,
-		lComma,
-		lAnySpace,
-	)
-	lParamList := lexer.And(
-		lParenOpen,
-		lexer.Group("params", lexer.Interlace(
-			lIdent,
-			lParamSep,
-		)),
-		lParenClose,
-	)
+// ParseReader parses a program read from r. The input is read as the
+// parser goes, and released again once it is no longer needed.
+func (p *Parser) ParseReader(r io.Reader) (*ast.Program, error) {
+	return p.parse(lexer.NewReaderScanner(r))
+}
 
-	// Keywords
-	lKeyLet := lexer.Atom("let")
-	lKeyReturn := lexer.Atom("return")
-	lKeyFunc := lexer.Atom("fn")
-
-	// Expressions and statements
-	var lExpr lexer.Lexer
-	var lStmt lexer.Lexer
-	lExpr = lexer.Future(&lExpr, "lExpr")
-	lStmt = lexer.Future(&lStmt, "lStmt")
-
-	// Expressions
-	lExprListSep := lexer.And(
-		lAnySpace,
-		lComma,
-		lAnySpace,
-	)
+// Grammar returns the lexer for whole programs, as compiled from
+// the grammar in sol.peg. It is the same lexer all parsers use, and
+// nil if sol.peg has no program rule.
+func Grammar() lexer.Lexer {
+	compileProgram()
+	return program.rules["program"]
+}
 
-	// (<expr>, <expr>, <expr>, ...)
-	lExprList := lexer.And(
-		lParenOpen,
-		lexer.Group("args", lexer.Interlace(
-			lExpr,
-			lExprListSep,
-		)),
-		lParenClose,
-	)
+// GeneratePrograms returns n random programs generated from the
+// grammar, see lexer.Generate. They are the same on every call, so they
+// may seed fuzz tests, and leave out the literals that the parser does
+// not build yet.
+func GeneratePrograms(n int) ([]string, error) {
+	compileProgram()
+	options := lexer.GenerateOptions{
+		MaxRepeat: 6,
+		Exclude:   []lexer.Lexer{program.rules["string"], program.rules["boolean"], program.rules["nil"]},
+	}
 
-	// ( <expr> )
-	lExprClosed := lexer.Group("exprClosed", lexer.And(
-		lParenOpen,
-		lAnySpace,
-		lExpr,
-		lAnySpace,
-		lParenClose,
-	))
-
-	// Primitive expressions
-	lExprPrimitive := lexer.Or(
-		lInteger,    // 5
-		lString,     // "string"
-		lFalse,      // false
-		lTrue,       // true
-		lNil,        // nil
-		lIdent,      // x
-		lExprClosed, // ( <expr> )
-	)
+	programs := make([]string, n)
+	for seed := range programs {
+		program, err := lexer.Generate(Grammar(), rand.New(rand.NewSource(int64(seed))), options)
+		if err != nil {
+			return nil, err
+		}
+		programs[seed] = program
+	}
+	return programs, nil
+}
 
-	// Define operators for all precedence types
-	lAssignmentOperators := lexer.And(
-		lAnySpace,
-		lexer.Group("operator", lexer.Atom("=")),
-		lAnySpace,
-	)
-	lUnaryOperators := lexer.Or(
-		lexer.Atom("!"),
-		lexer.Atom("-"),
-	)
-	lMultiplyOperators := lexer.And(
-		lAnySpace,
-		lexer.Group("operator", lexer.Or(
-			lexer.Atom("/"),
-			lexer.Atom("*"),
-		)),
-		lAnySpace,
-	)
-	lAddOperators := lexer.And(
-		lAnySpace,
-		lexer.Group("operator", lexer.Or(
-			lexer.Atom("+"),
-			lexer.Atom("-"),
-		)),
-		lAnySpace,
-	)
-	lCompareOperators := lexer.And(
-		lAnySpace,
-		lexer.Group("operator", lexer.Or(
-			lexer.Atom(">="),
-			lexer.Atom("<="),
-			lexer.Atom(">"),
-			lexer.Atom("<"),
-		)),
-		lAnySpace,
-	)
-	lEqualityOperators := lexer.And(
-		lAnySpace,
-		lexer.Group("operator", lexer.Or(
-			lexer.Atom("!="),
-			lexer.Atom("=="),
-		)),
-		lAnySpace,
-	)
+// compileProgram compiles the grammar in sol.peg into program, unless
+// that was already done.
+func compileProgram() {
+	program.once.Do(func() {
+		rules, grammarErr := lexer.CompileGrammar(grammar)
+		if grammarErr != nil {
+			panic("parser: invalid grammar in sol.peg: " + grammarErr.Error())
+		}
+		program.rules = rules
+
+		// ParseTree and Reparse need to find the statements of
+		// a program, and what may come in between them
+		for _, name := range []string{"program", "toplevel", "somespace", "anyspace"} {
+			if rules[name] == nil {
+				program.err = fmt.Errorf("parser: no %s rule in sol.peg", name)
+				return
+			}
+		}
+		program.statement = rules["toplevel"]
+		program.separator = rules["somespace"]
+		program.space = rules["anyspace"]
+		program.end = lexer.And(rules["anyspace"], lexer.EOF())
+	})
+}
 
-	// Declare all operators
-	var lAssignment, lUnary, lMultiply, lAdd, lCompare, lEquality lexer.Lexer
-	lAssignment = lexer.Future(&lAssignment, "assignment")
-	lUnary = lexer.Future(&lUnary, "unary")
-	lMultiply = lexer.Future(&lMultiply, "multiply")
-	lAdd = lexer.Future(&lAdd, "add")
-	lCompare = lexer.Future(&lCompare, "compare")
-	lEquality = lexer.Future(&lEquality, "equality")
-
-	// Define all operators
-	lAssignment = lexer.And(
-		lexer.Group("left", lExprPrimitive),
-		lexer.Group("rest", lexer.Repeat(
-			lexer.And(
-				lAssignmentOperators,
-				lexer.Group("right", lExprPrimitive),
-			), 0, 1,
-		)),
-	)
-	lUnary = lexer.Group("right", lexer.Or(
-		lexer.And(
-			lUnaryOperators,
-			lUnary,
-		),
-		lAssignment,
-	))
-	lMultiply = lexer.And(
-		lexer.Group("left", lUnary),
-		lexer.Group("rest", lexer.Repeat(
-			lexer.And(
-				lMultiplyOperators,
-				lexer.Group("right", lUnary),
-			), 0, 1,
-		)),
-	)
-	lAdd = lexer.And(
-		lexer.Group("left", lMultiply),
-		lexer.Group("rest", lexer.Repeat( // TODO: Net zoals hierboven overal de 'right' toevoegen
-			lexer.And(
-				lAddOperators,
-				lexer.Group("right", lMultiply),
-			), 0, 1,
-		)),
-	)
-	lCompare = lexer.And(
-		lexer.Group("left", lAdd),
-		lexer.Group("rest", lexer.Repeat(
-			lexer.And(
-				lCompareOperators,
-				lexer.Group("right", lAdd),
-			), 0, 1,
-		)),
-	)
-	lEquality = lexer.And(
-		lexer.Group("left", lCompare),
-		lexer.Group("rest", lexer.Repeat(
-			lexer.And(
-				lEqualityOperators,
-				lexer.Group("right", lCompare),
-			), 0, 1,
-		)),
-	)
+func (p *Parser) parse(s *lexer.Scanner) (*ast.Program, error) {
+	if p.program == nil {
+		return nil, p.err
+	}
+	tree, lexErr := p.program.Lex(p.scanner(s))
+	if err := p.lexed(s, lexErr); err != nil {
+		return nil, err
+	}
+	return p.build(tree)
+}
 
-	lExprFunc := lexer.Group("exprFunc", lexer.And(
-		lKeyFunc,   // fn
-		lAnySpace,  //
-		lParamList, // (a, b, c)
-		lAnySpace,  //
-		lBraceOpen, // {
-		lAnySpace,  //
-		lexer.Group("body", lexer.Repeat(lStmt, 0, -1)), // ...
-		lAnySpace,   //
-		lBraceClose, // }
-	))
-	lExprCall := lexer.Group("exprCall", lexer.And(
-		lIdent, // functionName
-		lAnySpace,
-		lExprList,
-	))
-	lExpr = lexer.Group("expression", lexer.Or(
-		lExprFunc, // fn(a, b) { <stmts> }
-		lExprCall, // fn(a, b)
-		lEquality, // strings, bools, ints
-	))
-
-	// Statements
-	lStmtDeclare := lexer.Group("stmtDeclare", lexer.And(
-		lKeyLet,         // let
-		lSomeSpace,      //
-		lIdent,          // <identifier>
-		lAnySpace,       //
-		lexer.Atom("="), // =
-		lAnySpace,       //
-		lExpr,           // <expression>
-	))
-	lStmtReturn := lexer.Group("stmtReturn", lexer.And(
-		lKeyReturn, // return
-		lSomeSpace, //
-		lExpr,      // <expression>
-	))
-	lStmtBlock := lexer.Group("stmtBlock", lexer.And(
-		lBraceOpen,
-		lAnySpace,
-		lexer.Repeat(
-			lexer.And(
-				lStmt,
-				lAnySpace,
-			), 0, -1,
-		),
-		lBraceClose,
-	))
-	lStmtExpr := lexer.Group("stmtExpr", lexer.And(
-		lExpr, // <expression>
-	))
-	lStmt = lexer.Group("statement", lexer.Or(
-		lStmtBlock,
-		lStmtDeclare,
-		lStmtReturn,
-		lStmtExpr,
-	))
-
-	// Program
-	lProgram := lexer.And(
-		lAnySpace,
-		lexer.Interlace(lStmt, lSomeSpace),
-		lAnySpace,
-	)
+// scanner prepares a scanner for lexing with this parser.
+func (p *Parser) scanner(s *lexer.Scanner) *lexer.Scanner {
+	s.EnableMemo()
+	if p.coverage != nil {
+		s.EnableCoverage(p.coverage)
+	}
+	return s
+}
 
-	// Actually lex the input
-	tree, lexErr := lProgram.Lex(s)
+// lex lexes a whole program, which must be valid UTF-8. It is lexed
+// statement by statement, in the parts the program rule is made of,
+// so that it may be reparsed from any of them, see Reparse.
+func (p *Parser) lex(s *lexer.Scanner) (*Tree, error) {
+	tree := &Tree{lexed: true, start: s.Position()}
+	_, lexErr := p.space.Lex(p.scanner(s))
+	if lexErr == nil {
+		lexErr = p.lexStatements(s, tree, nil)
+	}
+	return tree, p.lexed(s, lexErr)
+}
+
+// lexed returns why lexing with s failed, if it did.
+func (p *Parser) lexed(s *lexer.Scanner, lexErr *lexer.LexError) error {
+	if readErr := s.Err(); readErr != nil {
+		return readErr
+	}
+	if utf8Err := s.Validate(); utf8Err != nil {
+		return utf8Err
+	}
 	if lexErr != nil {
-		return nil, lexErr
+		return lexErr
 	}
-	// fmt.Println(tree.String(0))
+	return nil
+}
 
-	// Parse the tree
+// build parses the tree of a program, which may still hold
+// statements that could not be lexed.
+func (p *Parser) build(tree *lexer.LexNode) (*ast.Program, error) {
 	prog, parseErr := p.parseProgram(tree)
 	if parseErr != nil {
 		return nil, error(parseErr)
 	}
+
+	// Report the statements we had to skip, if any
+	var errs ErrorList
+	for _, lexErr := range tree.Errors() {
+		errs = append(errs, lexErr)
+	}
+	if len(errs) > 0 {
+		return prog, errs
+	}
 	return prog, nil
 }
 
@@ -346,16 +267,60 @@ func (p *Parser) parseProgram(node *lexer.LexNode) (*ast.Program, *ParseError) {
 	}
 
 	return &ast.Program{
+		Span:       span(node),
 		Statements: stmts,
 	}, nil
 }
 
+// The selectors that the parser finds the parts of nodes with.
+var (
+	selectDeclareIdent = lexer.MustCompileSelector("> stmtDeclare > identifier")
+	selectDeclareExpr  = lexer.MustCompileSelector("> stmtDeclare > expression")
+	selectReturnExpr   = lexer.MustCompileSelector("> stmtReturn > expression")
+	selectStmtExpr     = lexer.MustCompileSelector("> stmtExpr > expression")
+	selectLeft         = lexer.MustCompileSelector("> left")
+	selectRight        = lexer.MustCompileSelector("> right")
+	selectOperator     = lexer.MustCompileSelector("> operator")
+	selectExpr         = lexer.MustCompileSelector("> expression")
+	selectParams       = lexer.MustCompileSelector("> params > identifier")
+	selectBlock        = lexer.MustCompileSelector("> stmtBlock")
+	selectIdent        = lexer.MustCompileSelector("> identifier")
+	selectArgs         = lexer.MustCompileSelector("> args > expression")
+)
+
+// selectOne returns the only node within n that matches selector, see
+// lexer.LexNode.Select. Anything else means that the grammar and the
+// parser disagree.
+func selectOne(n *lexer.LexNode, selector *lexer.Selector, parsing string) (*lexer.LexNode, *ParseError) {
+	node, selectErr := selector.SelectOne(n)
+	if selectErr != nil {
+		return nil, err(n, selectErr.Error(), parsing)
+	}
+	return node, nil
+}
+
+// span returns the part of the source that n was lexed from.
+func span(n *lexer.LexNode) ast.Span {
+	return ast.Span{
+		Start: ast.Position(n.Start),
+		End:   ast.Position(n.End),
+	}
+}
+
 func (p *Parser) parseIdentifier(node *lexer.LexNode) (string, *ParseError) {
 	return node.Value, nil
 }
 
 func (p *Parser) parseStatement(node *lexer.LexNode) (ast.Statement, *ParseError) {
 
+	// Statements that failed to lex have already been reported
+	if node.Name == "error" {
+		return &ast.BadStatement{
+			Span:   span(node),
+			Source: node.Value,
+		}, nil
+	}
+
 	keyword := node.Children[0]
 	var stmt ast.Statement
 	var err *ParseError
@@ -381,8 +346,14 @@ func (p *Parser) parseStatement(node *lexer.LexNode) (ast.Statement, *ParseError
 func (p *Parser) parseDeclarationStatement(node *lexer.LexNode) (ast.Statement, *ParseError) {
 
 	// let <identifier> = <expression>
-	nodeIdent := node.GroupNode("identifier")
-	nodeExpr := node.GroupNode("expression")
+	nodeIdent, err := selectOne(node, selectDeclareIdent, "declaration statement")
+	if err != nil {
+		return nil, err
+	}
+	nodeExpr, err := selectOne(node, selectDeclareExpr, "declaration statement")
+	if err != nil {
+		return nil, err
+	}
 
 	// Parse identifier
 	ident, err := p.parseIdentifier(nodeIdent)
@@ -400,29 +371,40 @@ func (p *Parser) parseDeclarationStatement(node *lexer.LexNode) (ast.Statement,
 
 	// Return a declaration statement
 	return &ast.DeclarationStatement{
+		Span:       span(node),
 		Identifier: ident,
 		Expression: expr,
 	}, nil
 }
 
 func (p *Parser) parseReturnStatement(node *lexer.LexNode) (ast.Statement, *ParseError) {
-	expr, err := p.parseExpression(node.GroupNode("expression"))
+	nodeExpr, err := selectOne(node, selectReturnExpr, "return statement")
+	if err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpression(nodeExpr)
 	if err != nil {
 		return nil, err
 	}
 
 	return &ast.ReturnStatement{
+		Span:       span(node),
 		Expression: expr,
 	}, nil
 }
 
 func (p *Parser) parseExpressionStatement(node *lexer.LexNode) (ast.Statement, *ParseError) {
-	expr, err := p.parseExpression(node.GroupNode("expression"))
+	nodeExpr, err := selectOne(node, selectStmtExpr, "expression statement")
+	if err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpression(nodeExpr)
 	if err != nil {
 		return nil, err
 	}
 
 	return &ast.ExpressionStatement{
+		Span:       span(node),
 		Expression: expr,
 	}, nil
 }
@@ -438,6 +420,7 @@ func (p *Parser) parseBlockStatement(node *lexer.LexNode) (ast.Statement, *Parse
 	}
 
 	return &ast.BlockStatement{
+		Span:       span(node),
 		Statements: stmts,
 	}, nil
 }
@@ -450,68 +433,29 @@ func (p *Parser) parseExpression(node *lexer.LexNode) (ast.Expression, *ParseErr
 	// - Infix expressions: (a + b) - c, x - 4 * y
 	// - Primitive expressions: c, 5
 
-	// Handle function definitions and calls
-	if node.GroupName == "exprFunc" {
-		return p.parseFunctionExpression(node)
-	} else if node.GroupName == "exprCall" {
-		return p.parseCallExpression(node)
-	}
-
-	// Next, we want to move down to the primitive
-	// parts of this expression.
-
-	// Example expression, with two pointers indicating
-	// the positions and length of 'left' and 'rest':
-	//		5 + 5
-	//      ^ ^^^
-
-	var leftNode, restNode *lexer.LexNode
-	leftNode = node
-	restNode = nil
-
-	// We need to go deeper!
-	for (restNode == nil || restNode.Value == "") &&
-		leftNode.GroupExists("left") &&
-		leftNode.GroupExists("rest") {
-
-		current := leftNode
-		leftNode = current.GroupNode("left")
-		restNode = current.GroupNode("rest")
-	}
-
-	// If, at this point, the rest node is blank,
-	// then we must be dealing with a primitive.
-	if restNode == nil || restNode.Value == "" {
-		return p.parsePrimitiveExpression(leftNode)
-	}
-
-	// In all other cases, we are presumably dealing
-	// with an infix expression.
-
-	// Get the operator and right hand side expression if necessary
-	operatorNode := restNode.GroupNode("operator")
-	rightNode := restNode.GroupNode("right")
-
-	var left ast.Expression
-	var err *ParseError
-
-	left, err = p.parseExpression(leftNode)
-	if err != nil {
-		err.Trace(node, "expression")
-		return nil, err
-	}
-
-	right, err := p.parseExpression(rightNode)
-	if err != nil {
-		err.Trace(node, "expression")
-		return nil, err
+	// Functions, operators and parentheses may be wrapped in the
+	// groups and alternatives they were lexed through.
+	inner := node
+	for {
+		switch {
+		case inner.GroupName == "exprFunc":
+			return p.parseFunctionExpression(inner)
+		case inner.GroupName == "exprCall":
+			return p.parseCallExpression(inner)
+		case inner.Name == "infix":
+			return p.parseInfixExpression(inner)
+		case inner.Name == "prefix":
+			return p.parsePrefixExpression(inner)
+		case inner.GroupName == "exprClosed":
+			return p.parseClosedExpression(inner)
+		}
+		if len(inner.Children) != 1 {
+			break
+		}
+		inner = inner.Children[0]
 	}
 
-	return &ast.InfixExpression{
-		Left:     left,
-		Operator: operatorNode.Value,
-		Right:    right,
-	}, nil
+	return p.parsePrimitiveExpression(node)
 }
 
 func (p *Parser) parsePrimitiveExpression(node *lexer.LexNode) (ast.Expression, *ParseError) {
@@ -522,6 +466,7 @@ func (p *Parser) parsePrimitiveExpression(node *lexer.LexNode) (ast.Expression,
 	// Integers
 	if node.GroupNode("integer") != nil {
 		return &ast.IntegerExpression{
+			Span:    span(node),
 			Literal: node.Value,
 		}, nil
 	}
@@ -534,6 +479,7 @@ func (p *Parser) parsePrimitiveExpression(node *lexer.LexNode) (ast.Expression,
 	// Identifiers
 	if node.GroupNode("identifier") != nil {
 		return &ast.IdentifierExpression{
+			Span:    span(node),
 			Literal: node.Value,
 		}, nil
 	}
@@ -547,20 +493,31 @@ func (p *Parser) parsePrimitiveExpression(node *lexer.LexNode) (ast.Expression,
 
 func (p *Parser) parseIdentifierExpression(node *lexer.LexNode) (ast.Expression, *ParseError) {
 	return &ast.IdentifierExpression{
+		Span:    span(node),
 		Literal: node.Value,
 	}, nil
 }
 
 func (p *Parser) parseIntegerExpression(node *lexer.LexNode) (ast.Expression, *ParseError) {
 	return &ast.IntegerExpression{
+		Span:    span(node),
 		Literal: node.Value,
 	}, nil
 }
 
 func (p *Parser) parseInfixExpression(node *lexer.LexNode) (ast.Expression, *ParseError) {
-	leftNode := node.GroupNode("left")
-	rightNode := node.GroupNode("right")
-	operatorNode := node.GroupNode("operator")
+	leftNode, err := selectOne(node, selectLeft, "infix")
+	if err != nil {
+		return nil, err
+	}
+	rightNode, err := selectOne(node, selectRight, "infix")
+	if err != nil {
+		return nil, err
+	}
+	operatorNode, err := selectOne(node, selectOperator, "infix")
+	if err != nil {
+		return nil, err
+	}
 
 	left, err := p.parseExpression(leftNode)
 	if err != nil {
@@ -575,32 +532,65 @@ func (p *Parser) parseInfixExpression(node *lexer.LexNode) (ast.Expression, *Par
 	}
 
 	return &ast.InfixExpression{
+		Span:     span(node),
 		Left:     left,
 		Right:    right,
 		Operator: operatorNode.Value,
 	}, nil
 }
 
+func (p *Parser) parsePrefixExpression(node *lexer.LexNode) (ast.Expression, *ParseError) {
+	operatorNode, err := selectOne(node, selectOperator, "prefix")
+	if err != nil {
+		return nil, err
+	}
+	rightNode, err := selectOne(node, selectRight, "prefix")
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := p.parseExpression(rightNode)
+	if err != nil {
+		err.Trace(node, "prefix")
+		return nil, err
+	}
+
+	return &ast.PrefixExpression{
+		Span:     span(node),
+		Operator: operatorNode.Value,
+		Right:    right,
+	}, nil
+}
+
 func (p *Parser) parseClosedExpression(node *lexer.LexNode) (ast.Expression, *ParseError) {
-	return p.parseExpression(node.GroupNode("expression"))
+	nodeExpr, err := selectOne(node, selectExpr, "closed expression")
+	if err != nil {
+		return nil, err
+	}
+	return p.parseExpression(nodeExpr)
 }
 
 func (p *Parser) parseFunctionExpression(node *lexer.LexNode) (ast.Expression, *ParseError) {
 
 	var params []string
 
-	paramsNode := node.GroupNode("params")
-	for _, paramNode := range paramsNode.GroupNodes("identifier") {
+	paramNodes := selectParams.Select(node)
+	for _, paramNode := range paramNodes {
 		params = append(params, paramNode.Value)
 	}
 
-	block, err := p.parseBlockStatement(node.GroupNode("stmtBlock"))
+	blockNode, err := selectOne(node, selectBlock, "function")
+	if err != nil {
+		return nil, err
+	}
+	block, err := p.parseBlockStatement(blockNode)
 	if err != nil {
 		err.Trace(node, "function")
 		return nil, err
 	}
 
 	return &ast.FunctionExpression{
+		Span:       span(node),
 		Parameters: params,
 		Body:       block,
 	}, nil
@@ -608,10 +598,13 @@ func (p *Parser) parseFunctionExpression(node *lexer.LexNode) (ast.Expression, *
 
 func (p *Parser) parseCallExpression(node *lexer.LexNode) (ast.Expression, *ParseError) {
 
-	identifier := node.GroupNode("identifier").Value
+	identNode, err := selectOne(node, selectIdent, "call")
+	if err != nil {
+		return nil, err
+	}
+	identifier := identNode.Value
 
-	argsNode := node.GroupNode("args")
-	argsNodes := argsNode.GroupNodes("expression")
+	argsNodes := selectArgs.Select(node)
 	var args []ast.Expression
 
 	for _, argNode := range argsNodes {
@@ -624,6 +617,7 @@ func (p *Parser) parseCallExpression(node *lexer.LexNode) (ast.Expression, *Pars
 	}
 
 	return &ast.CallExpression{
+		Span:       span(node),
 		Identifier: identifier,
 		Arguments:  args,
 	}, nil
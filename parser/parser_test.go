@@ -1,9 +1,16 @@
 package parser
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/rand"
+	"reflect"
+	"sol/ast"
+	"sol/lexer"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 )
 
 func TestParseProgram(t *testing.T) {}
@@ -45,10 +52,23 @@ func TestParseExpressionStatement(t *testing.T) {
 		{"(5 + 91) - 43", "((5 + 91) - 43)", false},
 		{"5 + 5", "(5 + 5)", false},
 		{"1 + 2 * 3", "(1 + (2 * 3))", false},
+		{"1 + 2 + 3", "((1 + 2) + 3)", false},
+		{"8 / 4 * 2 - 1", "(((8 / 4) * 2) - 1)", false},
 		{"x", "x", false},
 		{"5 + 10", "(5 + 10)", false},
 		{"let x = 5", "let x = 5", false},
 		{"x = 10", "(x = 10)", false},
+		{"letter = nilly", "(letter = nilly)", false},
+		{"x = y = 1 + 2", "(x = (y = (1 + 2)))", false},
+		{"-x ** 2 % 3", "((-(x ** 2)) % 3)", false},
+		{"a or b and not c == d", "(a or (b and (not (c == d))))", false},
+		{"notx != !y", "(notx != (!y))", false},
+		{"1 /* one /* nested */ */ + 2 // two", "(1 + 2)", false},
+		{"// first\nlet x = 1 // second\n/* third */ x", "let x = 1x", false},
+		{"let f = fn(a, b) { a + b }", "let f = fn(a, b){(a + b)}", false},
+		{"let f = fn(a, b):\n    let c = a\n\n    c + b\nf", "let f = fn(a, b){let c = a(c + b)}f", false},
+		{"let f = fn(a):\n\tlet g = fn(b):\n\t\tb\n\tg\nf", "let f = fn(a){let g = fn(b){b}g}f", false},
+		{"let fnord = returned", "let fnord = returned", false},
 	}
 
 	for i, tt := range tests {
@@ -66,3 +86,460 @@ func TestParseExpressionStatement(t *testing.T) {
 		fmt.Print(progStr + "\n")
 	}
 }
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 +", `1:4: expected one of "not", "!", "-", integer, string, "false", "true", "nil", identifier, "("`},
+		{"add(1, 2", `1:9: expected one of "=", "or", "and", "!=", "==", ">=", "<=", ">", "<", "+", "-", "/", "*", "%", "**", ",", ")"`},
+		{"fn(a b) { }", `1:6: expected ","`},
+		{"x fn(a)", `1:8: expected one of "{", ":"`},
+		{"let x = 5\nlet y = \"\xff\"", "2:10: Invalid UTF-8 encoding"},
+		{"let return = 5", `1:5: expected identifier`},
+		{"x = let", `1:5: expected one of "not", "!", "-", integer, string, "false", "true", "nil", identifier, "("`},
+		{"fn", `1:3: expected "("`},
+		{"1 + /* open", "1:5: Unterminated comment"},
+		{"let x = 1\n/* /* */\nx", "2:1: Unterminated comment"},
+		{"let f = fn(a):\n\tx\n    y", "3:1: Mixed tabs and spaces in indentation"},
+		{"let f = fn(a):\nx", `2:1: expected one of newline, indented block`},
+		{"{ 5 + 5\n\n 6 + }", `3:6: expected one of "not", "!", "-", integer, string, "false", "true", "nil", identifier, "("`},
+	}
+
+	for i, tt := range tests {
+		p := NewParser()
+		_, err := p.Parse(tt.input)
+		if err == nil {
+			t.Fatalf("TestParseErrors[%d]: expected error", i)
+		}
+		if err.Error() != tt.expected {
+			t.Fatalf("TestParseErrors[%d]: expected=%q got=%q",
+				i, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestParsePartial(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+		errors []string
+	}{
+		{
+			"let x = 5\nx + * 2\nx + 1",
+			"let x = 5\n\nx\n\n<bad statement: + * 2>\n\n(x + 1)\n",
+			[]string{`2:5: expected one of "not", "!", "-", integer, string, "false", "true", "nil", identifier, "("`},
+		},
+		{
+			"{ 5 + }\n5 -\n",
+			"{\n5\n\n<bad statement: + >\n\n}\n5\n\n<bad statement: -\n>\n",
+			[]string{
+				`1:7: expected one of "not", "!", "-", integer, string, "false", "true", "nil", identifier, "("`,
+				`3:1: expected one of "not", "!", "-", integer, string, "false", "true", "nil", identifier, "("`,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		p := NewParser()
+		prog, err := p.Parse(tt.input)
+		if prog == nil {
+			t.Fatalf("TestParsePartial[%d]: expected partial program", i)
+		}
+		if prog.ToString() != tt.output {
+			t.Fatalf("TestParsePartial[%d]: expected=%q got=%q",
+				i, tt.output, prog.ToString())
+		}
+
+		errs, ok := err.(ErrorList)
+		if !ok || len(errs) != len(tt.errors) {
+			t.Fatalf("TestParsePartial[%d]: expected %d errors, got %v",
+				i, len(tt.errors), err)
+		}
+		for j, expected := range tt.errors {
+			if errs[j].Error() != expected {
+				t.Fatalf("TestParsePartial[%d]: error %d expected=%q got=%q",
+					i, j, expected, errs[j].Error())
+			}
+		}
+	}
+}
+
+func TestProgramJSON(t *testing.T) {
+	tests := []string{
+		"let x = 5\nx + 1",
+		"let f = fn(a, b):\n  return -a * b\nf(1, not 2)",
+		"{ x = 1 }\nx + * 2",
+	}
+
+	for i, input := range tests {
+		prog, _ := NewParser().Parse(input)
+		data, err := json.Marshal(prog)
+		if err != nil {
+			t.Fatalf("TestProgramJSON[%d]: unexpected error=%s", i, err.Error())
+		}
+
+		decoded := &ast.Program{}
+		if err := json.Unmarshal(data, decoded); err != nil {
+			t.Fatalf("TestProgramJSON[%d]: unexpected error=%s", i, err.Error())
+		}
+		if !reflect.DeepEqual(decoded, prog) {
+			t.Fatalf("TestProgramJSON[%d]: expected=%q got=%q",
+				i, prog.ToString(), decoded.ToString())
+		}
+	}
+
+	// Nodes know the type they were encoded from, and where they are
+	prog, _ := NewParser().Parse("let x = 5\nf(x)")
+	data, _ := json.Marshal(prog.Statements[1])
+	expected := `{"type":"ExpressionStatement","start":{"offset":10,"line":2,"column":1},` +
+		`"end":{"offset":14,"line":2,"column":5},"expression":{"type":"CallExpression",` +
+		`"start":{"offset":10,"line":2,"column":1},"end":{"offset":14,"line":2,"column":5},` +
+		`"identifier":"f","arguments":[{"type":"IdentifierExpression",` +
+		`"start":{"offset":12,"line":2,"column":3},"end":{"offset":13,"line":2,"column":4},"literal":"x"}]}}`
+	if string(data) != expected {
+		t.Fatalf("TestProgramJSON: expected=%s got=%s", expected, data)
+	}
+
+	node, err := ast.DecodeNode(data)
+	if _, ok := node.(*ast.ExpressionStatement); err != nil || !ok {
+		t.Fatalf("TestProgramJSON: expected an expression statement, got=%T error=%v", node, err)
+	}
+	if err := json.Unmarshal(data, &ast.ReturnStatement{}); err == nil ||
+		err.Error() != "ast: expected *ast.ReturnStatement, got *ast.ExpressionStatement" {
+		t.Fatalf("TestProgramJSON: expected a type error, got=%v", err)
+	}
+	if _, err := ast.DecodeNode([]byte(`{"type":"LoopStatement"}`)); err == nil ||
+		err.Error() != `ast: unknown node type "LoopStatement"` {
+		t.Fatalf("TestProgramJSON: expected an unknown type error, got=%v", err)
+	}
+}
+
+func TestGrammar(t *testing.T) {
+	for _, problem := range lexer.Analyze(Grammar()) {
+		t.Errorf("TestGrammar: %s", problem.String())
+	}
+}
+
+// TestParseGrammar checks that Parse, which lexes with a memo, and
+// ParseTree, which lexes statement by statement, reject the same
+// programs Grammar does on its own.
+func TestParseGrammar(t *testing.T) {
+	snippets := []string{
+		"", " ", "\n", "\n  ", "x", "42", "+", " * ", "(", ")", "{", "}", ":\n  ",
+		"let ", "let y = ", "return ", "fn(a, b)", "f(1, 2)", "/* c */", "// c\n", "=", ",",
+	}
+
+	programs, err := GeneratePrograms(50)
+	if err != nil {
+		t.Fatalf("TestParseGrammar: %s", err.Error())
+	}
+	inputs := []string{"z/**/(fn(h){}x\n", "q/**/(fn(d){}}\n"}
+	r := rand.New(rand.NewSource(1))
+	for _, program := range programs {
+		inputs = append(inputs, program)
+		for n := 0; n < 3; n++ {
+			offset := r.Intn(len(program) + 1)
+			deleted := r.Intn(len(program)-offset+1) % 8
+			program = program[:offset] + snippets[r.Intn(len(snippets))] + program[offset+deleted:]
+			inputs = append(inputs, program)
+		}
+	}
+
+	p := NewParser()
+	for i, input := range inputs {
+		_, lexErr := Grammar().Lex(lexer.NewScanner(input))
+		_, err := p.Parse(input)
+		if lexErr != nil && fmt.Sprint(err) != lexErr.Error() {
+			t.Fatalf("TestParseGrammar[%d]: expected error=%s got=%v for %q",
+				i, lexErr.Error(), err, input)
+		}
+		if _, ok := err.(*lexer.LexError); lexErr == nil && ok {
+			t.Fatalf("TestParseGrammar[%d]: unexpected error=%s for %q",
+				i, err.Error(), input)
+		}
+
+		tree, err := p.ParseTree(input)
+		reparsed(t, fmt.Sprintf("TestParseGrammar[%d]", i), tree, err)
+	}
+}
+
+// TestTypedStatements builds statements straight into ast values,
+// using the typed parsers of package lexer.
+func TestTypedStatements(t *testing.T) {
+	wordChar := lexer.Regex("[a-zA-Z0-9_]", false)
+	space := lexer.Regex("[ ]*", true)
+	symbol := func(text string) lexer.Lexer {
+		return lexer.And(space, lexer.Atom(text), space)
+	}
+
+	ident := lexer.Token(lexer.Reserved(lexer.Regex("[a-zA-Z]+", false), "let", "return"))
+	operand := lexer.Choice(
+		lexer.Map(lexer.Token(lexer.Regex("[0-9]+", false)), func(literal string) ast.Expression {
+			return &ast.IntegerExpression{Literal: literal}
+		}),
+		lexer.Map(ident, func(literal string) ast.Expression {
+			return &ast.IdentifierExpression{Literal: literal}
+		}),
+	)
+	type operation struct {
+		operator string
+		right    ast.Expression
+	}
+	expr := lexer.Seq2(operand, lexer.Many(lexer.Seq2(
+		lexer.Right(space, lexer.Token(lexer.Or(lexer.Atom("+"), lexer.Atom("-")))),
+		lexer.Right(space, operand),
+		func(operator string, right ast.Expression) operation {
+			return operation{operator, right}
+		},
+	), 0, -1), func(left ast.Expression, ops []operation) ast.Expression {
+		for _, op := range ops {
+			left = &ast.InfixExpression{Left: left, Operator: op.operator, Right: op.right}
+		}
+		return left
+	})
+	expr = lexer.Left(expr, space)
+
+	stmt := lexer.Choice(
+		lexer.Seq2(
+			lexer.Right(lexer.And(lexer.Keyword("let", wordChar), space), ident),
+			lexer.Right(symbol("="), expr),
+			func(name string, value ast.Expression) ast.Statement {
+				return &ast.DeclarationStatement{Identifier: name, Expression: value}
+			},
+		),
+		lexer.Map(lexer.Right(lexer.And(lexer.Keyword("return", wordChar), space), expr),
+			func(value ast.Expression) ast.Statement {
+				return &ast.ReturnStatement{Expression: value}
+			},
+		),
+		lexer.Map(expr, func(value ast.Expression) ast.Statement {
+			return &ast.ExpressionStatement{Expression: value}
+		}),
+	)
+	program := lexer.Map(lexer.Left(lexer.Many(lexer.Left(stmt, lexer.Atom("\n")), 0, -1), lexer.EOF()),
+		func(stmts []ast.Statement) *ast.Program {
+			return &ast.Program{Statements: stmts}
+		},
+	)
+
+	tests := []struct {
+		input  string
+		output string
+		err    string
+	}{
+		{"let x = 1 + y - 2\nreturn x\n", "let x = ((1 + y) - 2)\n\nreturn x\n", ""},
+		{"letter + 1\n", "(letter + 1)\n", ""},
+		{"let return = 1\n", "", "1:5: expected non-reserved word"},
+	}
+
+	for i, tt := range tests {
+		prog, err := program.Parse(lexer.NewScanner(tt.input))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("TestTypedStatements[%d]: expected error=%q got=%v", i, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestTypedStatements[%d]: unexpected error=%s", i, err.Error())
+		}
+		if prog.ToString() != tt.output {
+			t.Fatalf("TestTypedStatements[%d]: expected=%q got=%q", i, tt.output, prog.ToString())
+		}
+	}
+}
+
+func BenchmarkParseNested(b *testing.B) {
+	for _, depth := range []int{1, 4, 16, 64} {
+		input := strings.Repeat("( ", depth) + "1" + strings.Repeat(" )", depth)
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			p := NewParser()
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Parse(input); err != nil {
+					b.Fatalf("BenchmarkParseNested: %s", err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	tests := []string{
+		"let x = 5\nx + * 2\nx + 1",
+		"5 +",
+		"x \xff",
+		benchmarkSource(2000),
+	}
+
+	for i, input := range tests {
+		p := NewParser()
+		expectedProg, expectedErr := p.Parse(input)
+		prog, err := p.ParseReader(iotest.HalfReader(strings.NewReader(input)))
+
+		if fmt.Sprint(err) != fmt.Sprint(expectedErr) {
+			t.Fatalf("TestParseReader[%d]: expected error=%v got=%v",
+				i, expectedErr, err)
+		}
+		if (prog == nil) != (expectedProg == nil) ||
+			prog != nil && prog.ToString() != expectedProg.ToString() {
+			t.Fatalf("TestParseReader[%d]: expected=%v got=%v",
+				i, expectedProg, prog)
+		}
+	}
+}
+
+// TestParseConcurrent parses with a single parser from several
+// goroutines at once, which is meant to be run with -race.
+func TestParseConcurrent(t *testing.T) {
+	tests := []string{
+		"let x = 5\nx + * 2\nx + 1",
+		"let f = fn(a, b):\n  return a + b\nf(1, 2)",
+		"5 +",
+		benchmarkSource(200),
+	}
+
+	result := func(prog *ast.Program, err error) string {
+		if prog == nil {
+			return fmt.Sprint(err)
+		}
+		return fmt.Sprint(prog.ToString(), err)
+	}
+
+	p := NewParser()
+	expected := make([]string, len(tests))
+	for i, input := range tests {
+		expected[i] = result(p.Parse(input))
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := range tests {
+				i := (g + j) % len(tests)
+				var prog *ast.Program
+				var err error
+				if g%2 == 0 {
+					prog, err = p.Parse(tests[i])
+				} else {
+					prog, err = p.ParseReader(iotest.HalfReader(strings.NewReader(tests[i])))
+				}
+				if got := result(prog, err); got != expected[i] {
+					t.Errorf("TestParseConcurrent[%d]: expected=%q got=%q", i, expected[i], got)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestParseCoverage(t *testing.T) {
+	p := NewParser()
+	c := lexer.NewCoverage()
+	p.EnableCoverage(c)
+	for _, input := range []string{"let x = 5\nx + 1", "let f = fn(a) { return a }"} {
+		if _, err := p.Parse(input); err != nil {
+			t.Fatalf("TestParseCoverage: unexpected error=%s", err.Error())
+		}
+	}
+
+	report := c.Report(Grammar())
+	text := report.Text()
+	for _, line := range []string{
+		"rule program: 2 matched, 0 failed\n",
+		"rule declare: 2 matched",
+		"rule indentblock: 0 matched",
+		"  <- never matched",
+		"  <- never taken",
+	} {
+		if !strings.Contains(text, line) {
+			t.Fatalf("TestParseCoverage: expected %q in report, got=\n%s", line, text)
+		}
+	}
+
+	// Parsers without coverage don't count
+	NewParser().Parse("return 1")
+	if again := c.Report(Grammar()).Text(); again != text {
+		t.Fatalf("TestParseCoverage: expected unchanged report, got=\n%s", again)
+	}
+}
+
+// TestGeneratePrograms checks that the generated programs mostly
+// parse, so that the tests and fuzz tests seeded with them get past
+// lexing and building the program.
+func TestGeneratePrograms(t *testing.T) {
+	programs, err := GeneratePrograms(50)
+	if err != nil {
+		t.Fatalf("TestGeneratePrograms: %s", err.Error())
+	}
+
+	p := NewParser()
+	var failed []string
+	for _, program := range programs {
+		if _, err := p.Parse(program); err != nil {
+			failed = append(failed, fmt.Sprintf("%q: %s", program, err.Error()))
+		}
+	}
+	if len(failed) > len(programs)/10 {
+		t.Fatalf("TestGeneratePrograms: %d of %d programs failed to parse, e.g. %s",
+			len(failed), len(programs), failed[0])
+	}
+}
+
+// FuzzParse parses arbitrary input, which must never panic. The
+// corpus is seeded with random programs generated from the grammar.
+func FuzzParse(f *testing.F) {
+	programs, err := GeneratePrograms(50)
+	if err != nil {
+		f.Fatalf("FuzzParse: %s", err.Error())
+	}
+	for _, program := range programs {
+		f.Add(program)
+	}
+
+	p := NewParser()
+	f.Fuzz(func(t *testing.T, input string) {
+		prog, err := p.Parse(input)
+		if err == nil && prog == nil {
+			t.Fatalf("FuzzParse: no program and no error for %q", input)
+		}
+	})
+}
+
+// benchmarkSource returns a sol program with the given number of
+// statements, of roughly 20 bytes each.
+func benchmarkSource(statements int) string {
+	lines := []string{
+		"let alpha = 42",
+		"alpha + beta * (gamma - 7)",
+		"{ alpha = beta / 2 }",
+		"add(alpha, 10)",
+		"return alpha",
+	}
+
+	var b strings.Builder
+	for i := 0; i < statements; i++ {
+		b.WriteString(lines[i%len(lines)])
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, statements := range []int{100, 400} {
+		input := benchmarkSource(statements)
+		b.Run(fmt.Sprintf("bytes=%d", len(input)), func(b *testing.B) {
+			p := NewParser()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Parse(input); err != nil {
+					b.Fatalf("BenchmarkParse: %s", err.Error())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package parser
+
+import (
+	"fmt"
+	"sol/ast"
+	"sol/lexer"
+	"sort"
+	"sync"
+)
+
+// Tree is a parsed program along with its source, which can be parsed
+// again after an edit without lexing all of it, see Reparse.
+type Tree struct {
+	Source string
+
+	// The top level statements of the program, and where it starts
+	// and ends. There are none if the source could not be lexed.
+	statements []treeStatement
+	lexed      bool
+	start      lexer.Position
+	end        lexer.Position
+
+	once    sync.Once
+	program *ast.Program
+}
+
+// treeStatement is a top level statement of a tree, along with the
+// scanner's checkpoint right before it. Statements after an edit are
+// only moved: their node, and what was built from it, keep the
+// positions they were lexed at, until needed where they are now.
+type treeStatement struct {
+	node    *lexer.LexNode
+	built   ast.Statement // nil if it could not be built
+	errors  []*lexer.LexError
+	lexedAt lexer.Position
+
+	checkpoint lexer.Checkpoint
+}
+
+// Edit replaces Deleted bytes of a source, starting at byte Offset,
+// with Inserted.
+type Edit struct {
+	Offset   int
+	Deleted  int
+	Inserted string
+}
+
+// Program returns the parsed program, or nil if the source could not
+// be parsed. It is put together on first use, from the statements as
+// they were built when lexed, moved to where they are now.
+func (t *Tree) Program() *ast.Program {
+	t.once.Do(func() {
+		if !t.lexed {
+			return
+		}
+		stmts := make([]ast.Statement, len(t.statements))
+		for i, stmt := range t.statements {
+			if stmt.built == nil {
+				return
+			}
+			stmts[i] = stmt.built
+			if from, to := stmt.lexedAt, stmt.checkpoint.Position(); from != to {
+				stmts[i] = ast.Moved(stmt.built, ast.Position(from), ast.Position(to))
+			}
+		}
+		t.program = &ast.Program{
+			Span:       ast.Span{Start: ast.Position(t.start), End: ast.Position(t.end)},
+			Statements: stmts,
+		}
+	})
+	return t.program
+}
+
+// ParseTree parses a program like Parse does, but returns it as a
+// tree that may be reparsed after edits. The tree is returned even
+// when parsing failed, so the source may be fixed by further edits.
+// Programs are lexed as space, toplevel statements separated by
+// somespace, and space again, as the program rule of sol.peg does.
+func (p *Parser) ParseTree(input string) (*Tree, error) {
+	if p.err != nil {
+		return &Tree{Source: input}, p.err
+	}
+	s := lexer.NewScanner(input)
+	s.EnableCheckpoints()
+
+	tree, err := p.lex(s)
+	if err != nil {
+		return &Tree{Source: input}, err
+	}
+	tree.Source = input
+	return tree, p.check(tree)
+}
+
+// Reparse parses the source of tree after the edit, and returns the
+// same program and errors Parse would. Only the statements that were
+// lexed by looking at the edited input are lexed again. The ones
+// before them are reused, and so are the ones after them, which are
+// only moved to where they are now. The given tree is left as it is.
+func (p *Parser) Reparse(tree *Tree, edit Edit) (*Tree, error) {
+	if edit.Offset < 0 || edit.Deleted < 0 || edit.Offset+edit.Deleted > len(tree.Source) {
+		return nil, fmt.Errorf("parser: edit of %d bytes at offset %d is outside of the %d byte source",
+			edit.Deleted, edit.Offset, len(tree.Source))
+	}
+	source := tree.Source[:edit.Offset] + edit.Inserted + tree.Source[edit.Offset+edit.Deleted:]
+	if !tree.lexed {
+		return p.ParseTree(source)
+	}
+
+	s := p.scanner(lexer.NewScanner(source))
+	s.EnableCheckpoints()
+	reparsed := &Tree{Source: source, lexed: true, start: tree.start}
+
+	// Resume from the last statement that was lexed without looking
+	// at the edit, and keep all statements before it
+	first := sort.Search(len(tree.statements), func(i int) bool {
+		return tree.statements[i].checkpoint.Examined() > edit.Offset
+	}) - 1
+	var lexErr *lexer.LexError
+	if first < 0 {
+		_, lexErr = p.space.Lex(s)
+	} else {
+		s.Resume(tree.statements[first].checkpoint)
+		reparsed.statements = append([]treeStatement{}, tree.statements[:first]...)
+	}
+
+	// Once past the edit, the statements after it are reused as soon
+	// as lexing would go on as it did before one of them
+	delta := len(edit.Inserted) - edit.Deleted
+	next := 0
+	resync := func(c lexer.Checkpoint) bool {
+		at := c.Position()
+		if at.Offset < edit.Offset+len(edit.Inserted) {
+			return false
+		}
+		for next < len(tree.statements) && tree.statements[next].checkpoint.Position().Offset+delta < at.Offset {
+			next++
+		}
+		if next == len(tree.statements) || tree.statements[next].checkpoint.Position().Offset+delta != at.Offset {
+			return false
+		}
+		old := tree.statements[next].checkpoint
+		if !c.Continues(old) {
+			return false
+		}
+
+		for _, stmt := range tree.statements[next:] {
+			stmt.checkpoint = stmt.checkpoint.Moved(old, c)
+			reparsed.statements = append(reparsed.statements, stmt)
+		}
+		reparsed.end = tree.end.Moved(old.Position(), at)
+		return true
+	}
+	if lexErr == nil {
+		lexErr = p.lexStatements(s, reparsed, resync)
+	}
+	if err := p.lexed(s, lexErr); err != nil {
+		return &Tree{Source: source}, err
+	}
+	return reparsed, p.check(reparsed)
+}
+
+// newStatement builds a statement of a tree, which was lexed as node
+// right after the checkpoint.
+func (p *Parser) newStatement(node *lexer.LexNode, checkpoint lexer.Checkpoint) treeStatement {
+	built, parseErr := p.parseStatement(node)
+	if parseErr != nil {
+		built = nil
+	}
+	return treeStatement{
+		node:       node,
+		built:      built,
+		errors:     node.Errors(),
+		lexedAt:    checkpoint.Position(),
+		checkpoint: checkpoint,
+	}
+}
+
+// check returns the error Parse would return for the program of tree,
+// which is the first statement that could not be built, or else the
+// statements that could not be lexed, if any.
+func (p *Parser) check(tree *Tree) error {
+	var errs ErrorList
+	for _, stmt := range tree.statements {
+		from, to := stmt.lexedAt, stmt.checkpoint.Position()
+		if stmt.built == nil {
+
+			// Build it again where it is now, for the positions
+			node := stmt.node
+			if from != to {
+				node = node.Moved(from, to)
+			}
+			_, parseErr := p.parseStatement(node)
+			parseErr.Trace(&lexer.LexNode{Start: tree.start, End: tree.end}, "program")
+			return parseErr
+		}
+		for _, lexErr := range stmt.errors {
+			if from != to {
+				lexErr = lexErr.Moved(from, to)
+			}
+			errs = append(errs, lexErr)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// lexStatements lexes the statements of a program into tree, from
+// right before one, followed by the space at the end of the program.
+// Before each statement, resync is given the checkpoint there, and
+// may finish the tree instead.
+func (p *Parser) lexStatements(s *lexer.Scanner, tree *Tree, resync func(lexer.Checkpoint) bool) *lexer.LexError {
+
+	// Statements are separated by space, which is only part of the
+	// program if another statement follows it
+	separated := false
+	for {
+		checkpoint := s.Checkpoint()
+		if resync != nil && resync(checkpoint) {
+			if separated {
+				s.Discard()
+			}
+			return nil
+		}
+
+		stmt, lexErr := p.statement.Lex(s)
+		if lexErr != nil {
+			if !separated || lexErr.Committed() || s.Committed() {
+				return lexErr
+			}
+			s.Pop()
+			break
+		}
+		if separated {
+			s.Discard()
+		}
+		tree.statements = append(tree.statements, p.newStatement(stmt, checkpoint))
+
+		s.Push()
+		if _, lexErr := p.separator.Lex(s); lexErr != nil {
+			committed := lexErr.Committed() || s.Committed()
+			s.Pop()
+			if committed {
+				return lexErr
+			}
+			break
+		}
+		separated = true
+	}
+
+	if _, lexErr := p.end.Lex(s); lexErr != nil {
+		return lexErr
+	}
+	tree.end = s.Position()
+	return nil
+}
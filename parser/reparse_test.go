@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reparsed checks that a tree reparsed after an edit matches a full
+// parse of the edited source.
+func reparsed(t *testing.T, name string, tree *Tree, err error) {
+	t.Helper()
+	p := NewParser()
+	prog, parseErr := p.Parse(tree.Source)
+	if fmt.Sprint(err) != fmt.Sprint(parseErr) {
+		t.Fatalf("%s: expected error=%v got=%v for %q", name, parseErr, err, tree.Source)
+	}
+	if !reflect.DeepEqual(tree.Program(), prog) {
+		expected, _ := json.MarshalIndent(prog, "", " ")
+		got, _ := json.MarshalIndent(tree.Program(), "", " ")
+		t.Fatalf("%s: expected program=%s got=%s for %q", name, expected, got, tree.Source)
+	}
+}
+
+func TestReparse(t *testing.T) {
+	tests := []struct {
+		input    string
+		edit     Edit
+		expected string
+	}{
+		// Within a statement, and across statements
+		{"let x = 5\nx + 1\nx", Edit{10, 1, "y"}, "let x = 5\ny + 1\nx"},
+		{"let x = 5\nx + 1\nx", Edit{8, 1, "42 * 2"}, "let x = 42 * 2\nx + 1\nx"},
+		{"let x = 5\nx + 1\nx", Edit{10, 6, ""}, "let x = 5\nx"},
+		{"let x = 5\nx + 1\nx", Edit{0, 0, "1 "}, "1 let x = 5\nx + 1\nx"},
+		{"let x = 5\nx + 1\nx", Edit{17, 0, " + 2"}, "let x = 5\nx + 1\nx + 2"},
+
+		// Statements that merge or split
+		{"x\ny\nz", Edit{1, 1, " + "}, "x + y\nz"},
+		{"x + y\nz", Edit{1, 3, "\n"}, "x\ny\nz"},
+		{"x\ny\nz", Edit{2, 0, "+ "}, "x\n+ y\nz"},
+
+		// Blocks that grow or end
+		{"let f = fn(a):\n  return a\nf(1)", Edit{25, 0, "\n  a"}, "let f = fn(a):\n  return a\n  a\nf(1)"},
+		{"let f = fn(a):\n  return a\nf(1)", Edit{15, 2, ""}, "let f = fn(a):\nreturn a\nf(1)"},
+		{"{ x }\ny", Edit{4, 1, ""}, "{ x \ny"},
+
+		// Statements that could not be lexed
+		{"x\n)\ny\n)", Edit{0, 1, "let"}, "let\n)\ny\n)"},
+		{"x\n)\ny", Edit{2, 1, "z"}, "x\nz\ny"},
+		{"x\ny\nz", Edit{2, 1, "("}, "x\n(\nz"},
+
+		// Edits that can't be lexed at all
+		{"x\ny", Edit{3, 0, "\xff"}, "x\ny\xff"},
+		{"x\ny", Edit{0, 3, ""}, ""},
+		{"x\ny\nz", Edit{2, 0, "fn("}, "x\nfn(y\nz"},
+	}
+
+	p := NewParser()
+	for i, tt := range tests {
+		tree, _ := p.ParseTree(tt.input)
+		reparsedTree, err := p.Reparse(tree, tt.edit)
+		if reparsedTree.Source != tt.expected {
+			t.Fatalf("TestReparse[%d]: expected source=%q got=%q", i, tt.expected, reparsedTree.Source)
+		}
+		reparsed(t, fmt.Sprintf("TestReparse[%d]", i), reparsedTree, err)
+
+		// The tree that was edited is left as it is
+		if tree.Source != tt.input {
+			t.Fatalf("TestReparse[%d]: expected unchanged source=%q got=%q", i, tt.input, tree.Source)
+		}
+	}
+
+	// Edits must lie within the source
+	tree, _ := p.ParseTree("x")
+	if _, err := p.Reparse(tree, Edit{1, 1, ""}); err == nil ||
+		err.Error() != "parser: edit of 1 bytes at offset 1 is outside of the 1 byte source" {
+		t.Fatalf("TestReparse: expected edit error, got=%v", err)
+	}
+
+	// Statements before and after an edit are reused, and only
+	// built again where they moved
+	input := benchmarkSource(100)
+	tree, _ = p.ParseTree(input)
+	offset := strings.Index(input, "return alpha") + len("return ")
+	for _, inserted := range []string{"gamma", "gamma + 1"} {
+		edited, _ := p.Reparse(tree, Edit{offset, len("alpha"), inserted})
+		before, after := tree.statements, edited.statements
+		last := len(before) - 1
+		if len(before) != len(after) || before[0].node != after[0].node || before[last].node != after[last].node {
+			t.Fatalf("TestReparse[%q]: expected reused statements", inserted)
+		}
+
+		stmts, edits := tree.Program().Statements, edited.Program().Statements
+		moved := len(inserted) != len("alpha")
+		if stmts[0] != edits[0] || (stmts[last] == edits[last]) != !moved {
+			t.Fatalf("TestReparse[%q]: expected reused statements in the program, moved=%v", inserted, moved)
+		}
+		reparsed(t, fmt.Sprintf("TestReparse[%q]", inserted), edited, nil)
+	}
+
+	// Which takes about as much for a longer program
+	allocs := func(statements int) float64 {
+		input := benchmarkSource(statements)
+		tree, _ := p.ParseTree(input)
+		offset := len(input)/2 + strings.Index(input[len(input)/2:], "return alpha") + len("return ")
+		return testing.AllocsPerRun(10, func() {
+			p.Reparse(tree, Edit{offset, len("alpha"), "gamma + 1"})
+		})
+	}
+	if short, long := allocs(100), allocs(400); long > short+20 {
+		t.Fatalf("TestReparse: expected as many allocations for 400 statements as for 100, got=%.0f and %.0f",
+			long, short)
+	}
+}
+
+func TestReparseRandom(t *testing.T) {
+	snippets := []string{
+		"", " ", "\n", "\n  ", "x", "42", "+", " * ", "(", ")", "{", "}", ":\n  ",
+		"let ", "let y = ", "return ", "fn(a, b)", "f(1, 2)", "/* c */", "// c\n", "=", ",",
+	}
+
+	p := NewParser()
+	r := rand.New(rand.NewSource(1))
+	programs, err := GeneratePrograms(30)
+	if err != nil {
+		t.Fatalf("TestReparseRandom: %s", err.Error())
+	}
+	for seed, input := range programs {
+
+		// Keep editing the same tree, as an editor would
+		tree, _ := p.ParseTree(input)
+		for n := 0; n < 20; n++ {
+			offset := r.Intn(len(tree.Source) + 1)
+			deleted := r.Intn(len(tree.Source)-offset+1) % 8
+			inserted := snippets[r.Intn(len(snippets))]
+
+			tree, err = p.Reparse(tree, Edit{offset, deleted, inserted})
+			reparsed(t, fmt.Sprintf("TestReparseRandom[%d][%d]", seed, n), tree, err)
+		}
+	}
+}
+
+func BenchmarkReparse(b *testing.B) {
+	for _, statements := range []int{100, 400} {
+		input := benchmarkSource(statements)
+		b.Run(fmt.Sprintf("bytes=%d", len(input)), func(b *testing.B) {
+			p := NewParser()
+			tree, err := p.ParseTree(input)
+			if err != nil {
+				b.Fatalf("BenchmarkReparse: %s", err.Error())
+			}
+
+			// Rename what a statement in the middle returns
+			offset := len(input)/2 + strings.Index(input[len(input)/2:], "return alpha") + len("return ")
+			edit := Edit{offset, len("alpha"), "beta"}
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Reparse(tree, edit); err != nil {
+					b.Fatalf("BenchmarkReparse: %s", err.Error())
+				}
+			}
+		})
+	}
+}
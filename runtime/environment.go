@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"fmt"
+	"math"
 	"sol/ast"
 	"strconv"
 )
@@ -19,7 +20,15 @@ func NewEnv() *Environment {
 		ops["-"] = applySubtract
 		ops["*"] = applyMultiply
 		ops["/"] = applyDivide
+		ops["%"] = applyModulo
+		ops["**"] = applyPower
 		ops["="] = applyAssign
+		ops["=="] = applyEqual
+		ops["!="] = applyNotEqual
+		ops["<"] = compareNumbers(func(l, r int) bool { return l < r })
+		ops["<="] = compareNumbers(func(l, r int) bool { return l <= r })
+		ops[">"] = compareNumbers(func(l, r int) bool { return l > r })
+		ops[">="] = compareNumbers(func(l, r int) bool { return l >= r })
 	}
 	return &Environment{
 		scope: NewScope(),
@@ -32,6 +41,7 @@ func (e *Environment) Evaluate(node ast.Node) Object {
 	case *ast.Program:
 		prog, _ := node.(*ast.Program)
 		var last Object
+		last = &Nil{}
 		for _, stmt := range prog.Statements {
 			last = e.Evaluate(stmt)
 		}
@@ -70,7 +80,7 @@ func (e *Environment) Evaluate(node ast.Node) Object {
 	case *ast.IntegerExpression:
 		num, err := strconv.Atoi(node.ToString())
 		if err != nil {
-			panic(err)
+			return &Exception{Message: fmt.Sprintf("Integer out of range: %s", node.ToString())}
 		}
 		return &Number{Value: num}
 
@@ -78,7 +88,6 @@ func (e *Environment) Evaluate(node ast.Node) Object {
 		return e.scope.Get(node.ToString())
 
 	case *ast.ClosedExpression:
-		fmt.Println("closedExpr")
 		expr, _ := node.(*ast.ClosedExpression)
 		return e.Evaluate(expr.Expression)
 
@@ -86,11 +95,33 @@ func (e *Environment) Evaluate(node ast.Node) Object {
 		expr, _ := node.(*ast.InfixExpression)
 		return e.applyOperator(expr.Operator, expr.Left, expr.Right)
 
+	case *ast.PrefixExpression:
+		expr, _ := node.(*ast.PrefixExpression)
+		return applyPrefix(expr.Operator, e.Evaluate(expr.Right))
+
+	case nil:
+		return &Exception{Message: "Missing expression"}
+
 	}
-	panic(fmt.Sprintf("Uninterpreted AST node encountered: %s", node.ToString()))
+	return &Exception{Message: fmt.Sprintf("Uninterpreted AST node encountered: %s", node.ToString())}
 }
 
 func (e *Environment) applyOperator(op string, left, right ast.Expression) Object {
+
+	// The right hand side of and/or is only evaluated if needed
+	switch op {
+	case "and":
+		if !isTruthy(e.Evaluate(left)) {
+			return &Boolean{Value: false}
+		}
+		return &Boolean{Value: isTruthy(e.Evaluate(right))}
+	case "or":
+		if isTruthy(e.Evaluate(left)) {
+			return &Boolean{Value: true}
+		}
+		return &Boolean{Value: isTruthy(e.Evaluate(right))}
+	}
+
 	fn, ok := ops[op]
 	if ok {
 		return fn(e.Evaluate(left), e.Evaluate(right))
@@ -144,12 +175,114 @@ func applyDivide(left, right Object) Object {
 	if lt == "number" && lt == rt {
 		leftNum, _ := left.(*Number)
 		rightNum, _ := right.(*Number)
+		if rightNum.Value == 0 {
+			return &Exception{Message: "Cannot divide by zero"}
+		}
 		return &Number{Value: leftNum.Value / rightNum.Value}
 	}
 
 	return &Exception{Message: "Cannot divide non-numbers"}
 }
 
+func applyModulo(left, right Object) Object {
+	lt := left.TypeString()
+	rt := right.TypeString()
+
+	if lt == "number" && lt == rt {
+		leftNum, _ := left.(*Number)
+		rightNum, _ := right.(*Number)
+		if rightNum.Value == 0 {
+			return &Exception{Message: "Cannot take modulo by zero"}
+		}
+		return &Number{Value: leftNum.Value % rightNum.Value}
+	}
+
+	return &Exception{Message: "Cannot take modulo of non-numbers"}
+}
+
+func applyPower(left, right Object) Object {
+	lt := left.TypeString()
+	rt := right.TypeString()
+
+	if lt == "number" && lt == rt {
+		leftNum, _ := left.(*Number)
+		rightNum, _ := right.(*Number)
+		if rightNum.Value < 0 {
+			return &Exception{Message: "Cannot raise to a negative power"}
+		}
+		// Square and multiply, so that large powers take no time
+		result, base := 1, leftNum.Value
+		inRange := true
+		for exponent := rightNum.Value; exponent > 0 && inRange; exponent /= 2 {
+			if exponent%2 == 1 {
+				result, inRange = multiply(result, base)
+			}
+			if exponent > 1 && inRange {
+				base, inRange = multiply(base, base)
+			}
+		}
+		if !inRange {
+			return &Exception{Message: fmt.Sprintf("Integer out of range: %d ** %d", leftNum.Value, rightNum.Value)}
+		}
+		return &Number{Value: result}
+	}
+
+	return &Exception{Message: "Cannot raise non-numbers to a power"}
+}
+
+// multiply returns a * b, and whether that didn't overflow.
+func multiply(a, b int) (int, bool) {
+	product := a * b
+	if a != 0 && (product/a != b || a == -1 && b == math.MinInt) {
+		return product, false
+	}
+	return product, true
+}
+
+func applyEqual(left, right Object) Object {
+	return &Boolean{Value: left.IsEqual(right)}
+}
+
+func applyNotEqual(left, right Object) Object {
+	return &Boolean{Value: !left.IsEqual(right)}
+}
+
+func compareNumbers(compare func(int, int) bool) func(Object, Object) Object {
+	return func(left, right Object) Object {
+		leftNum, lok := left.(*Number)
+		rightNum, rok := right.(*Number)
+		if lok && rok {
+			return &Boolean{Value: compare(leftNum.Value, rightNum.Value)}
+		}
+		return &Exception{Message: "Cannot compare non-numbers"}
+	}
+}
+
+func applyPrefix(op string, right Object) Object {
+	switch op {
+	case "-":
+		if num, ok := right.(*Number); ok {
+			return &Number{Value: -num.Value}
+		}
+		return &Exception{Message: "Cannot negate non-numbers"}
+	case "!", "not":
+		return &Boolean{Value: !isTruthy(right)}
+	}
+	return &Nil{}
+}
+
+// isTruthy returns whether an object counts as true in conditions.
+// Only false and nil don't.
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Nil:
+		return false
+	}
+	return true
+}
+
 func applyAssign(left, right Object) Object {
 	return left
 }
@@ -15,8 +15,27 @@ func TestEvaluate(t *testing.T) {
 		{"5 * 5", "25"},
 		{"5 + 1 * 3", "8"},
 		{"1 + 3 * 5", "16"},
+		{"10 - 2 - 3", "5"},
+		{"8 / 4 / 2", "1"},
+		{"7 % 4 + 2 ** 3 ** 2", "515"},
+		{"-2 ** 2", "-4"},
+		{"10 - -3", "13"},
+		{"1 < 2 and 3 > 4", "false"},
+		{"1 < 2 or 3 > 4", "true"},
+		{"not 1 == 2", "true"},
+		{"!(2 >= 2)", "false"},
+		{"5 % 0", "Cannot take modulo by zero"},
 		{"let x = 5", "5"},
 		{"let x = 5 x + 10", "15"},
+		{"7 / 0", "Cannot divide by zero"},
+		{"3 ** 39", "4052555153018976267"},
+		{"2 ** 62", "4611686018427387904"},
+		{"(0 - 2) ** 63", "-9223372036854775808"},
+		{"1 ** 4611686018427387904", "1"},
+		{"2 ** 63", "Integer out of range: 2 ** 63"},
+		{"2 ** 4611686018427387904", "Integer out of range: 2 ** 4611686018427387904"},
+		{"9223372036854775808", "Integer out of range: 9223372036854775808"},
+		{"fn(a) { a }", "Uninterpreted AST node encountered: fn(a){\na\n\n}"},
 	}
 
 	for i, tt := range tests {
@@ -38,3 +57,25 @@ func TestEvaluate(t *testing.T) {
 	}
 
 }
+
+// FuzzEvaluate evaluates whatever parses, including programs with
+// statements that didn't, which must never panic. The corpus is
+// seeded with random programs generated from the grammar.
+func FuzzEvaluate(f *testing.F) {
+	programs, err := parser.GeneratePrograms(50)
+	if err != nil {
+		f.Fatalf("FuzzEvaluate: %s", err.Error())
+	}
+	for _, program := range programs {
+		f.Add(program)
+	}
+	f.Add("1 / 0\n99999999999999999999 ** 99999999999\nx +")
+
+	p := parser.NewParser()
+	f.Fuzz(func(t *testing.T, input string) {
+		prog, _ := p.Parse(input)
+		if prog != nil {
+			NewEnv().Evaluate(prog)
+		}
+	})
+}